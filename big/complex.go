@@ -82,7 +82,8 @@ func (z *Complex) Div(a, b *Complex) *Complex {
 	left, right := new(big.Float), new(big.Float)
 	bottom := b.AbsSq()
 
-	real := new(big.Float).Add(left.Mul(&a.R, &b.R), right.Mul(&a.I, &b.I)).Quo(&z.R, bottom)
+	real := new(big.Float).Add(left.Mul(&a.R, &b.R), right.Mul(&a.I, &b.I))
+	real.Quo(real, bottom)
 	z.I.Sub(left.Mul(&a.I, &b.R), right.Mul(&a.R, &b.I)).Quo(&z.I, bottom)
 	z.R.Copy(real)
 	return z
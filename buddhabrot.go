@@ -0,0 +1,178 @@
+package mandelbrot
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Density modes for Config.Density.
+const (
+	// DensityBuddhabrot counts the points visited by the orbits which
+	// escape. With three Bands, it is a Nebulabrot.
+	DensityBuddhabrot = "buddhabrot"
+	// DensityAnti counts the points visited by the orbits which don't
+	// escape, the anti-Buddhabrot.
+	DensityAnti = "anti_buddhabrot"
+)
+
+// DefaultSamples is the number of random points sampled for each pixel of a
+// Buddhabrot when Config.Samples is 0.
+const DefaultSamples = 20
+
+// maxBands is the most Bands a Buddhabrot may have, one for each of red,
+// green and blue.
+const maxBands = 3
+
+// samplesPerChunk is the number of points sampled by a worker at a time.
+// Each chunk has its own random numbers, seeded from Config.Seed and the
+// chunk's number, so that the result doesn't depend on which worker did it.
+const samplesPerChunk = 4096
+
+// sampleRadius is the radius of the disc from which points are sampled,
+// which holds all of the set.
+const sampleRadius = 2.0
+
+// calculateDensity computes a Buddhabrot for CalculateContext. cfg has been
+// validated, and its size matches the Grid. Checkpoints aren't made.
+func (g *Grid) calculateDensity(ctx context.Context, cfg Config, opts CalcOptions) error {
+	g.makeHits(len(cfg.bands()))
+	g.References = 0
+
+	samples := cfg.Samples
+	if samples == 0 {
+		samples = DefaultSamples * g.Width * g.Height
+	}
+	chunks := (samples + samplesPerChunk - 1) / samplesPerChunk
+	d, err := newDensity(g, cfg)
+	if err != nil {
+		return err
+	}
+
+	m := newMeter(opts, samples, 0)
+	return forEach(ctx, chunks, func(chunk int) {
+		n := samplesPerChunk
+		if last := samples - chunk*samplesPerChunk; last < n {
+			n = last
+		}
+		rng := rand.New(rand.NewSource(mix(cfg.Seed, int64(chunk))))
+		orbit := make([]complex128, 0, d.iterations)
+		for i := 0; i < n; i++ {
+			// uniformly in the disc
+			r, theta := sampleRadius*math.Sqrt(rng.Float64()), 2*math.Pi*rng.Float64()
+			orbit = d.orbit(complex(r*math.Cos(theta), r*math.Sin(theta)), orbit[:0])
+			d.record(orbit)
+		}
+		m.add(n, 0)
+	})
+}
+
+// density iterates points and records their orbits in a Grid's Hits.
+type density struct {
+	g            *Grid
+	p            *Params
+	mandelbrot   bool // p's formula is z^2 + c
+	anti         bool
+	bands        []int
+	iterations   int // the most of the bands
+	left, top    float64
+	xStep, yStep float64
+}
+
+func newDensity(g *Grid, cfg Config) (*density, error) {
+	p, err := cfg.Params()
+	if err != nil {
+		return nil, err
+	}
+	bands := cfg.bands()
+	iterations := 0
+	for _, n := range bands {
+		if n > iterations {
+			iterations = n
+		}
+	}
+	return &density{
+		g:          g,
+		p:          p,
+		mandelbrot: p.Formula.mandelbrot(),
+		anti:       cfg.Density == DensityAnti,
+		bands:      bands,
+		iterations: iterations,
+		left:       cfg.CenterReal - cfg.PlotWidth/2,
+		top:        cfg.CenterImag + cfg.PlotHeight/2,
+		xStep:      cfg.PlotWidth / float64(cfg.XRes),
+		yStep:      cfg.PlotHeight / float64(cfg.YRes)}, nil
+}
+
+// orbit appends the orbit of the sampled point s (c, or the starting z of a
+// Julia set) to orbit, up to d.iterations or until it escapes. So the orbit
+// escaped if it is shorter than d.iterations.
+func (d *density) orbit(s complex128, orbit []complex128) []complex128 {
+	z, c := complex128(0), s
+	if d.p.Julia {
+		z, c = s, d.p.C
+	} else if d.mandelbrot && !d.anti && InBulb(c) > 0 {
+		// never escapes, so there is nothing to record
+		return orbit
+	}
+
+	radius := d.p.radius()
+	r2 := radius * radius
+	for i := 0; i < d.iterations; i++ {
+		if d.mandelbrot {
+			z = z*z + c
+		} else {
+			z, _ = d.p.Formula.step(z, 0)
+			z += c
+		}
+		if abs2(z) > r2 {
+			return orbit
+		}
+		orbit = append(orbit, z)
+	}
+	return orbit
+}
+
+// record adds the points of orbit to the Hits of each band it belongs to.
+// For a Buddhabrot, an orbit which escaped belongs to the bands of at least
+// as many iterations. For an anti-Buddhabrot, an orbit which didn't escape
+// within a band's iterations belongs to it, with that many of its points.
+func (d *density) record(orbit []complex128) {
+	for b, n := range d.bands {
+		points := orbit
+		switch {
+		case d.anti && len(orbit) < n:
+			continue
+		case d.anti:
+			points = orbit[:n]
+		case len(orbit) >= n:
+			continue
+		}
+		if !d.p.Julia && len(points) > 0 {
+			// the first point is c, which only shows where the samples
+			// were
+			points = points[1:]
+		}
+		hits := d.g.Hits[b]
+		for _, z := range points {
+			x := int(math.Floor((real(z) - d.left) / d.xStep))
+			y := int(math.Floor((d.top - imag(z)) / d.yStep))
+			if x >= 0 && x < d.g.Width && y >= 0 && y < d.g.Height {
+				atomic.AddUint32(&hits[y*d.g.Width+x], 1)
+			}
+		}
+	}
+}
+
+// mix combines a seed and a chunk number into a seed for the chunk's random
+// numbers, so that the chunks of different seeds don't overlap.
+//
+// Math from
+// https://prng.di.unimi.it/splitmix64.c
+func mix(seed, chunk int64) int64 {
+	z := uint64(seed) + uint64(chunk+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
@@ -0,0 +1,168 @@
+package mandelbrot
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func buddhaConfig() Config {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 40, 30
+	cfg.PlotWidth, cfg.PlotHeight = 4, 3
+	cfg.CenterReal = -0.5
+	cfg.Iterations = 200
+	cfg.Density = DensityBuddhabrot
+	cfg.Samples = 50000
+	cfg.Seed = 7
+	return cfg
+}
+
+func TestBuddhabrot(t *testing.T) {
+	cfg := buddhaConfig()
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Hits) != 1 {
+		t.Fatalf("%d bands of hits, want 1", len(g.Hits))
+	}
+	total := uint64(0)
+	for _, h := range g.Hits[0] {
+		total += uint64(h)
+	}
+	if total == 0 {
+		t.Fatal("no hits")
+	}
+
+	// the same seed gives the same plot, whichever workers did what
+	again := NewGrid(cfg.XRes, cfg.YRes)
+	if err := again.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Hits, g.Hits) {
+		t.Error("hits differ for the same seed")
+	}
+	cfg.Seed++
+	if err := again.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(again.Hits, g.Hits) {
+		t.Error("hits are the same for different seeds")
+	}
+
+	// the orbits are symmetric about the real axis, as the plot is
+	top, bottom := uint64(0), uint64(0)
+	for y := 0; y < cfg.YRes/2; y++ {
+		for x := 0; x < cfg.XRes; x++ {
+			top += uint64(g.Hits[0][y*cfg.XRes+x])
+			bottom += uint64(g.Hits[0][(cfg.YRes-1-y)*cfg.XRes+x])
+		}
+	}
+	if d := float64(top) - float64(bottom); d*d > 0.01*float64(top)*float64(top) {
+		t.Errorf("hits above the axis = %d, below = %d", top, bottom)
+	}
+}
+
+func TestNebulabrot(t *testing.T) {
+	cfg := buddhaConfig()
+	cfg.Bands = []int{200, 50, 20}
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// every orbit escaping within 20 iterations escapes within 50 and 200
+	for i := range g.Hits[0] {
+		if g.Hits[0][i] < g.Hits[1][i] || g.Hits[1][i] < g.Hits[2][i] {
+			t.Fatalf("hits of point %d = %d, %d, %d, want decreasing", i, g.Hits[0][i], g.Hits[1][i], g.Hits[2][i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeGrid(&buf, g, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := DecodeGrid(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Hits, g.Hits) {
+		t.Error("DecodeGrid() hits differ from encoded hits")
+	}
+
+	cfg.Coloring = ColorBuddha
+	img, err := got.Colorize(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the most hit point of each band is at full brightness
+	for b, hits := range g.Hits {
+		most, at := uint32(0), 0
+		for i, h := range hits {
+			if h > most {
+				most, at = h, i
+			}
+		}
+		c := img.At(at%cfg.XRes, at/cfg.XRes).(color.RGBA)
+		if v := []uint8{c.R, c.G, c.B}[b]; v != 255 {
+			t.Errorf("band %d at its most hit point is %d, want 255", b, v)
+		}
+	}
+}
+
+func TestAntiBuddhabrot(t *testing.T) {
+	cfg := buddhaConfig()
+	cfg.Density = DensityAnti
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the orbits never escape, so nothing is plotted beyond the radius
+	in, out := uint64(0), uint64(0)
+	for y := 0; y < cfg.YRes; y++ {
+		for x := 0; x < cfg.XRes; x++ {
+			c := complex(-2.5+(float64(x)+0.5)*0.1, 1.5-(float64(y)+0.5)*0.1)
+			if abs2(c) < 1 {
+				in += uint64(g.Hits[0][y*cfg.XRes+x])
+			} else if abs2(c) > 2.1*2.1 {
+				out += uint64(g.Hits[0][y*cfg.XRes+x])
+			}
+		}
+	}
+	if in == 0 || out != 0 {
+		t.Errorf("hits within 1 = %d, beyond 2.1 = %d", in, out)
+	}
+
+	ramp := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+	cfg.Coloring = ColorBuddha
+	if _, err := g.Colorize(ramp, cfg); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewGrid(2, 2).Colorize(ramp, cfg); !errors.Is(err, ErrBadData) {
+		t.Errorf("Colorize() without hits error = %v, want ErrBadData", err)
+	}
+}
+
+func TestBuddhabrotConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		change func(*Config)
+		field  string
+	}{
+		{func(c *Config) { c.Density = "nebula" }, "density"},
+		{func(c *Config) { c.Expression = "z^3 + c" }, "density"},
+		{func(c *Config) { c.Samples = -1 }, "samples"},
+		{func(c *Config) { c.Bands = []int{1, 2, 3, 4} }, "bands"},
+		{func(c *Config) { c.Bands = []int{100, 0} }, "bands"},
+	} {
+		cfg := buddhaConfig()
+		tt.change(&cfg)
+		var ce *ConfigError
+		if err := cfg.Validate(); !errors.As(err, &ce) || ce.Field != tt.field {
+			t.Errorf("Validate() error = %v, want one for %s", err, tt.field)
+		}
+	}
+}
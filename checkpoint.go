@@ -0,0 +1,190 @@
+package mandelbrot
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCheckpointInterval is how often a checkpoint is saved when
+// CalcOptions.CheckpointInterval is 0.
+const DefaultCheckpointInterval = time.Minute
+
+// CheckpointFile gets the name of the checkpoint file kept next to a data
+// file while it is being calculated.
+func CheckpointFile(dataFile string) string {
+	return dataFile + ".ckpt"
+}
+
+// Checkpoints are data files (see EncodeGrid) with an extra channel marking
+// which rows are done. Only those rows hold results.
+
+// checkpoint keeps track of the rows of a Grid which are done, and saves them
+// to a file so that the calculation can be resumed.
+type checkpoint struct {
+	filename string
+	g        *Grid
+	cfg      Config
+
+	mu   sync.Mutex
+	done []uint8 // 1 for each row done
+
+	quit  chan struct{}
+	saved chan error
+}
+
+func newCheckpoint(filename string, g *Grid, cfg Config) *checkpoint {
+	return &checkpoint{
+		filename: filename,
+		g:        g,
+		cfg:      cfg,
+		done:     make([]uint8, g.Height)}
+}
+
+// resume copies the rows done in the checkpoint file, if there is one, into
+// the Grid. The error wraps ErrCheckpointMismatch if the file is for a
+// different plot.
+func (c *checkpoint) resume() error {
+	file, err := os.Open(c.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var rows []uint8
+	g, cfg, err := decodeGrid(bufio.NewReader(file), func(g *Grid) map[uint8]interface{} {
+		rows = make([]uint8, g.Height)
+		return map[uint8]interface{}{channelRows: rows}
+	})
+	if err != nil {
+		return fmt.Errorf("reading checkpoint %s: %w", c.filename, err)
+	}
+	if g.Width != c.g.Width || g.Height != c.g.Height || !cfg.samePlot(c.cfg) {
+		return fmt.Errorf("%w: %s", ErrCheckpointMismatch, c.filename)
+	}
+
+	for y, done := range rows {
+		// rows with glitches are done again, since what's needed to fix
+		// them isn't saved
+		if done != 0 && !g.rowGlitched(y) {
+			c.g.copyRow(g, y)
+			c.done[y] = 1
+		}
+	}
+	return nil
+}
+
+// isDone reports if row y is done.
+func (c *checkpoint) isDone(y int) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[y] != 0
+}
+
+// rowDone records that row y of the Grid is done. It must not change after.
+func (c *checkpoint) rowDone(y int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.done[y] = 1
+	c.mu.Unlock()
+}
+
+// save writes the rows done so far to the checkpoint file. The file is
+// replaced only once it is completely written, so an interruption leaves the
+// previous checkpoint intact.
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	done := append([]uint8(nil), c.done...)
+	c.mu.Unlock()
+
+	// other rows may be being written
+	snapshot := NewGrid(c.g.Width, c.g.Height)
+	for y, d := range done {
+		if d != 0 {
+			snapshot.copyRow(c.g, y)
+		}
+	}
+
+	tmp := c.filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := encodeGrid(w, snapshot, c.cfg, map[uint8]interface{}{channelRows: done}); err != nil {
+		return fmt.Errorf("writing checkpoint %s: %w", tmp, err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.filename)
+}
+
+// start saves the checkpoint every interval until stop is called.
+func (c *checkpoint) start(interval time.Duration) {
+	c.quit, c.saved = make(chan struct{}), make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var err error
+		for {
+			select {
+			case <-ticker.C:
+				if e := c.save(); err == nil {
+					err = e
+				}
+			case <-c.quit:
+				c.saved <- err
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the saving begun by start, and saves the checkpoint one last
+// time. It returns the first error from saving.
+func (c *checkpoint) stop() error {
+	close(c.quit)
+	err := <-c.saved
+	if e := c.save(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// copyRow copies row y of src, which must be the same size, to the Grid.
+func (g *Grid) copyRow(src *Grid, y int) {
+	i, j := y*g.Width, (y+1)*g.Width
+	copy(g.Iterations[i:j], src.Iterations[i:j])
+	copy(g.Smooth[i:j], src.Smooth[i:j])
+	copy(g.Distance[i:j], src.Distance[i:j])
+	copy(g.Period[i:j], src.Period[i:j])
+	copy(g.Flags[i:j], src.Flags[i:j])
+	copy(g.Root[i:j], src.Root[i:j])
+	copy(g.Trap[i:j], src.Trap[i:j])
+}
+
+// rowGlitched reports if any point of row y is glitched.
+func (g *Grid) rowGlitched(y int) bool {
+	for _, f := range g.Flags[y*g.Width : (y+1)*g.Width] {
+		if f&FlagGlitch != 0 {
+			return true
+		}
+	}
+	return false
+}
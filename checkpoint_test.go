@@ -0,0 +1,58 @@
+package mandelbrot
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	cfg := NewConfig()
+	cfg.CenterReal, cfg.PlotWidth, cfg.PlotHeight = -0.75, 2.5, 2
+	cfg.XRes, cfg.YRes = 40, 200
+	want := NewGrid(cfg.XRes, cfg.YRes)
+	if err := want.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupt the calculation part way
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := CalcOptions{
+		Checkpoint: filepath.Join(t.TempDir(), "test.dat.ckpt"),
+		Progress: func(p Progress) {
+			if p.Rows >= cfg.YRes/2 {
+				cancel()
+			}
+		}}
+	if err := NewGrid(cfg.XRes, cfg.YRes).CalculateContext(ctx, cfg, opts); err != context.Canceled {
+		t.Fatalf("CalculateContext() = %v, want context.Canceled", err)
+	}
+
+	var first Progress
+	opts.Resume = true
+	opts.Progress = func(p Progress) {
+		if first.TotalRows == 0 {
+			first = p
+		}
+	}
+	got := NewGrid(cfg.XRes, cfg.YRes)
+	if err := got.CalculateContext(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if first.Rows <= cfg.YRes/2 || first.Rows >= cfg.YRes {
+		t.Errorf("first progress when resuming = %d rows, want more than %d and less than all", first.Rows, cfg.YRes/2)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("resumed Grid differs from one calculated at once")
+	}
+
+	// the checkpoint is of a different plot now
+	cfg.Iterations++
+	err := NewGrid(cfg.XRes, cfg.YRes).CalculateContext(context.Background(), cfg, opts)
+	if !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("CalculateContext() with another plot's checkpoint = %v, want ErrCheckpointMismatch", err)
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"image"
+	"image/color"
 	mbrot "mandelbrot"
 	"mandelbrot/cmd"
+	"reflect"
 	"time"
 )
 
@@ -19,15 +22,44 @@ func main() {
 	cmd.VPrint(verbose, "Reading data file...\n")
 
 	// read data
-	coords := mbrot.ReadData(cfg.DataFile)
+	grid, plot, err := mbrot.ReadData(cfg.DataFile)
+	cmd.Check(err)
+	// the plot is the data file's, in the colors of cfg, unless it is a
+	// legacy file without a Config
+	if !reflect.DeepEqual(plot, mbrot.Config{}) {
+		cfg = plot.WithColors(cfg)
+	}
 
-	// output to jpg
+	// output in the format of the file's extension
 	cmd.VPrint(verbose, fmt.Sprintf("Writing image to %s\n", cfg.ImageFile))
 
-	ramp := mbrot.MakeRamp(mbrot.ReadStops(cfg.RampFile))
-	mbrot.OutputToJPG(
-		mbrot.CreatePicture(coords, ramp, cfg.XRes, cfg.YRes, mbrot.HexToRGBA(cfg.SetColor)),
-		cfg.ImageFile)
+	var img image.Image
+	meta := &mbrot.Metadata{Config: cfg} // so the image can be made again
+	if cfg.Coloring == mbrot.ColorBasin && len(cfg.RampFiles) > 0 {
+		// a ramp for each root
+		ramps := make([][]color.RGBA, len(cfg.RampFiles))
+		for i, file := range cfg.RampFiles {
+			var stops []mbrot.Stop
+			ramps[i], stops = readRamp(file)
+			meta.Ramps = append(meta.Ramps, stops)
+		}
+		img, err = grid.ColorizeBasins(ramps, cfg)
+	} else {
+		ramp, stops := readRamp(cfg.RampFile)
+		meta.Ramps = [][]mbrot.Stop{stops}
+		img, err = grid.Colorize(ramp, cfg)
+	}
+	cmd.Check(err)
+	cmd.Check(mbrot.WriteImage(img, cfg.ImageFile, cfg.Quality, meta))
 
 	cmd.VPrint(verbose, fmt.Sprintf("Took %0.4f seconds.\n", time.Since(start).Seconds()))
 }
+
+// readRamp reads the color stops in filename and makes a ramp of them.
+func readRamp(filename string) ([]color.RGBA, []mbrot.Stop) {
+	stops, err := mbrot.LoadStops(filename)
+	cmd.Check(err)
+	ramp, err := mbrot.MakeRamp(stops)
+	cmd.Check(err)
+	return ramp, stops
+}
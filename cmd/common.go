@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	mbrot "mandelbrot"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
 )
 
 // VPrint prints str if verbose is true.
@@ -14,29 +19,78 @@ func VPrint(verbose bool, str string) {
 	}
 }
 
+// Check reports err and exits the program with a non-zero status, if err
+// isn't nil.
+func Check(err error) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "\n%s: interrupted\n", filepath.Base(os.Args[0]))
+		os.Exit(130)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filepath.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+}
+
+// CheckCalculation is Check for the error from a calculation which saved
+// checkpoints to checkpoint, telling how to continue if it was interrupted.
+func CheckCalculation(err error, checkpoint string) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "\nProgress was saved to %s. Run again with -resume to continue.", checkpoint)
+	}
+	Check(err)
+}
+
+// Context gets a context which is cancelled by Ctrl-C, so that calculations
+// using it stop.
+func Context() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// Progress gets options for calculations which print the progress twice a
+// second if verbose is true.
+func Progress(verbose bool) mbrot.CalcOptions {
+	if !verbose {
+		return mbrot.CalcOptions{}
+	}
+	return mbrot.CalcOptions{
+		Interval: 500 * time.Millisecond,
+		Progress: func(p mbrot.Progress) {
+			// the ansi escape code here moves the cursor left 100 characters
+			fmt.Printf("\u001b[100D %0.1f%% complete, %s left.  ",
+				p.Fraction()*100, p.ETA.Round(time.Second))
+		}}
+}
+
 // Startup performs common startup tasks for commands, such as parsing
-// command line arguments and loading the program configuration file.
+// command line arguments and loading the program configuration file. The
+// configuration may also be loaded from an image made by colorize or
+// zoomvid, which records it.
 func Startup() (mbrot.Config, bool) {
 	var configFile string
 	var writeDefault bool
 	var verbose bool
 
-	flag.StringVar(&configFile, "config", "", "The file with configuration data (in json format).")
+	flag.StringVar(&configFile, "config", "", "The file with configuration data (in json format), or an image to make again.")
 	flag.BoolVar(&writeDefault, "default", false, "Set this flag to output a default config file to 'default.json'. The program will then exit.")
 	flag.BoolVar(&verbose, "v", false, "Show verbose output when set.")
 	flag.Parse()
 
 	if writeDefault {
-		mbrot.WriteDefault()
+		Check(mbrot.WriteDefault())
 		os.Exit(0)
 	}
 
 	if configFile == "" {
-		panic("Config file not specified.")
+		Check(errors.New("config file not specified (use -config)"))
 	}
 
-	cfg := mbrot.ReadConfig(configFile)
-	// TODO: check config for errors
+	read := mbrot.ReadConfig
+	if _, err := mbrot.ImageFormat(configFile); err == nil {
+		read = mbrot.ReadImageConfig
+	}
+	cfg, err := read(configFile)
+	Check(err)
 
 	return cfg, verbose
 }
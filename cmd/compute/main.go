@@ -1,15 +1,18 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	mbrot "mandelbrot"
 	"mandelbrot/cmd"
+	"os"
 	"time"
 )
 
 func main() {
 
 	// parse command line, load config, etc
+	resume := flag.Bool("resume", false, "Continue from the checkpoint left by an interrupted run.")
 	cfg, verbose := cmd.Startup()
 
 	// print configuration settings
@@ -20,45 +23,36 @@ func main() {
 	start := time.Now() // to show processing time when finished
 
 	// choose mandelbrot set or julia set
-	var action mbrot.Action
-	if cfg.DoJulia() {
+	if cfg.Density != "" {
+		cmd.VPrint(verbose, fmt.Sprintf("Calculating the %s.\n", cfg.Density))
+	} else if cfg.DoJulia() {
 		cmd.VPrint(verbose, "Calculating the Julia set.\n")
-		action = func(n complex128) (bool, int) {
-			return mbrot.IsMemberJulia(n, cfg.GetJulia(), cfg.Iterations)
-		}
+	} else if cfg.UsePerturbation() {
+		cmd.VPrint(verbose, "Calculating the Mandelbrot set using perturbation.\n")
 	} else {
 		cmd.VPrint(verbose, "Calculating the Mandelbrot set.\n")
-		action = func(n complex128) (bool, int) {
-			return mbrot.IsMemberMandelbrot(n, cfg.Iterations)
-		}
 	}
 
-	// progress output if verbose mode is on
-	var progress float64
-	if verbose {
-		go func() {
-			ticker := time.NewTicker(1 * time.Second)
-			for progress < 1.0 {
-				select {
-				case <-ticker.C:
-					// the ansi escape code here moves the cursor left 100 characters
-					fmt.Printf("\u001b[100D%0.1f%% complete.", progress*100)
-				default:
-				}
-			}
-			ticker.Stop()
-		}()
+	// the data for the set, with progress output if verbose mode is on
+	ctx, stop := cmd.Context()
+	defer stop()
+	opts := cmd.Progress(verbose)
+	opts.Checkpoint = mbrot.CheckpointFile(cfg.DataFile)
+	opts.Resume = *resume
+	grid := mbrot.NewGrid(cfg.XRes, cfg.YRes)                                    // set up
+	cmd.CheckCalculation(grid.CalculateContext(ctx, cfg, opts), opts.Checkpoint) // do the work
+	if grid.References > 0 {
+		cmd.VPrint(verbose, fmt.Sprintf("\nUsed %d reference orbit(s).", grid.References))
 	}
 
-	// the data for the set
-	coords := mbrot.Set{}
-	coords.Initialize(cfg)                      // set up
-	coords.CalculateProgress(action, &progress) // do the work
-
 	// output data
 	cmd.VPrint(verbose, fmt.Sprintf("\nWriting data to %s.\n", cfg.DataFile))
 
-	mbrot.WriteData(coords, cfg.DataFile)
+	cmd.Check(mbrot.WriteData(grid, cfg, cfg.DataFile))
+	// the data is safe now; Buddhabrots don't leave a checkpoint
+	if err := os.Remove(opts.Checkpoint); !os.IsNotExist(err) {
+		cmd.Check(err)
+	}
 
 	cmd.VPrint(verbose, fmt.Sprintf("Took %0.4f seconds.\n", time.Since(start).Seconds()))
 
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	mbrot "mandelbrot"
+	"mandelbrot/cmd"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+
+	format := flag.String("format", mbrot.ExportNPY, "The format to export: npy, raw (float32 with a json sidecar) or csv.")
+	out := flag.String("out", "", "The start of the names of the files written. Defaults to the data file's name without its extension.")
+	cfg, verbose := cmd.Startup()
+
+	start := time.Now() // to show processing time when finished
+
+	base := *out
+	if base == "" {
+		base = strings.TrimSuffix(cfg.DataFile, filepath.Ext(cfg.DataFile))
+	}
+
+	// read the data file and write its values
+	cmd.VPrint(verbose, fmt.Sprintf("Exporting %s as %s...\n", cfg.DataFile, *format))
+	files, err := mbrot.ExportData(cfg.DataFile, base, *format)
+	cmd.Check(err)
+	for _, file := range files {
+		cmd.VPrint(verbose, fmt.Sprintf("Wrote %s\n", file))
+	}
+
+	cmd.VPrint(verbose, fmt.Sprintf("Took %0.4f seconds.\n", time.Since(start).Seconds()))
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
 	m "mandelbrot"
 	"mandelbrot/cmd"
 	"math"
+	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,15 +24,26 @@ func main() {
 	flag.Float64Var(&zoomFactor, "zoom", 1.1, "How 'fast' the zoom happens. 1.05 or 1.1 is generally appropriate. Must be >1.")
 	flag.Float64Var(&iterFactor, "iter", 0.02, "Rate of increase of the iterations. Equals 1/<DoubleEveryNFrames> (eg 0.02 = 1/25).")
 	showInfo := flag.Bool("info", false, "When set, display info only and do no computation.")
+	resume := flag.Bool("resume", false, "Skip the frames already made, and continue the frame left by an interrupted run from its checkpoint.")
 	cfg, verbose := cmd.Startup()
 
 	// params for image generation and saving
-	path := makeOutputDir(cfg.ImageFile)
-	ramp := m.MakeRamp(m.ReadStops(cfg.RampFile))
-	setColor := m.HexToRGBA(cfg.SetColor)
-
-	totalFrames := totalFrames(zoomFactor, cfg.PlotWidth)
-	totalTime := 0.0
+	path, err := makeOutputDir(cfg.ImageFile)
+	cmd.Check(err)
+	ext := filepath.Ext(cfg.ImageFile) // frames are in the format of the image file
+	stops, err := m.LoadStops(cfg.RampFile)
+	cmd.Check(err)
+	ramp, err := m.MakeRamp(stops)
+	cmd.Check(err)
+
+	// the final width may be far too small for a float64, so all widths are
+	// computed with the precision the deepest frame needs
+	prec, err := cfg.Precision()
+	cmd.Check(err)
+	finalWidth, err := cfg.Width(prec)
+	cmd.Check(err)
+	totalFrames := totalFrames(startWidth, zoomFactor, finalWidth)
+	totalTime, framesMade := 0.0, 0
 
 	if *showInfo {
 		fmt.Printf("Config info:\n------------\n%s\n----------\n", cfg)
@@ -42,43 +55,60 @@ func main() {
 	// alter plot_width,plot_height, iterations, image_file in order,
 	// producing a series of images which 'zoom' into the configured point.
 	// do this non-concurrently to save CPU for mandelbrot calcs and to prevent
-	// excessive use of memory (keeping all the Sets in memory).
+	// excessive use of memory (keeping all the Grids in memory).
 
-	origPlotWidth := cfg.PlotWidth
+	ctx, stop := cmd.Context()
+	defer stop()
 	origIterations := cfg.Iterations
-	for i := 0; cfg.PlotWidth >= origPlotWidth; i++ {
+	width := new(big.Float).SetPrec(prec).SetFloat64(startWidth)
+	zoom := new(big.Float).SetPrec(prec).SetFloat64(zoomFactor)
+	for i := 0; width.Cmp(finalWidth) >= 0; i, width = i+1, width.Quo(width, zoom) {
 		start := time.Now()
 
 		// setup parameters for this frame
-		cfg.PlotWidth = startWidth * math.Pow(zoomFactor, float64(-i))
+		cfg.BigPlotWidth = width.Text('e', 20)
+		cfg.PlotWidth, _ = width.Float64()
 		cfg.PlotHeight = cfg.PlotWidth * (float64(cfg.YRes) / float64(cfg.XRes))
 		cfg.Iterations = origIterations * 1 << uint(float64(i)*iterFactor)
-		cfg.ImageFile = filepath.Join(path, fmt.Sprintf("%010d.jpg", i))
-		action := func(n complex128) (bool, int) {
-			return m.IsMemberMandelbrot(n, cfg.Iterations)
+		cfg.ImageFile = filepath.Join(path, fmt.Sprintf("%010d%s", i, ext))
+		if _, err := os.Stat(cfg.ImageFile); *resume && err == nil {
+			cmd.VPrint(verbose, fmt.Sprintf("Frame %d of %d already made.\n", i+1, totalFrames))
+			continue
 		}
 
 		// show status
-		var setProgress float64
 		if verbose {
 			fmt.Printf("Frame %d of %d\n", i+1, totalFrames)
 			fmt.Printf(" Iterations: %d\n", cfg.Iterations)
-			fmt.Printf(" Plot width: %0.8e\n", cfg.PlotWidth)
-			showProgress(&setProgress)
+			fmt.Printf(" Plot width: %s\n", width.Text('e', 8))
+			if cfg.UsePerturbation() {
+				fmt.Println(" Using perturbation.")
+			}
 		}
 
 		// do work
-		coords := m.Set{}
-		coords.Initialize(cfg)
-		coords.CalculateProgress(action, &setProgress)
+		opts := cmd.Progress(verbose)
+		opts.Checkpoint = m.CheckpointFile(cfg.DataFile)
+		opts.Resume = *resume
+		grid := m.NewGrid(cfg.XRes, cfg.YRes)
+		cmd.CheckCalculation(grid.CalculateContext(ctx, cfg, opts), opts.Checkpoint)
 
 		// output image
-		img := m.CreatePicture(coords, ramp, cfg.XRes, cfg.YRes, setColor)
-		m.OutputToJPG(img, cfg.ImageFile)
+		img, err := grid.Colorize(ramp, cfg)
+		cmd.Check(err)
+		cmd.Check(writeFrame(img, cfg.ImageFile, &m.Metadata{Config: cfg, Ramps: [][]m.Stop{stops}}))
+		// the frame is safe now; Buddhabrots don't leave a checkpoint
+		if err := os.Remove(opts.Checkpoint); !os.IsNotExist(err) {
+			cmd.Check(err)
+		}
 
 		took := time.Since(start).Seconds()
 		totalTime += took
+		framesMade++
 		if verbose {
+			if grid.References > 0 {
+				fmt.Printf("\n Used %d reference orbit(s).", grid.References)
+			}
 			fmt.Printf("\n Took %0.1f seconds.\n\n", took)
 		}
 
@@ -88,43 +118,37 @@ func main() {
 	if verbose {
 		fmt.Println("-------------------------")
 		fmt.Printf("Total time: %0.1f seconds.\n", totalTime)
-		fmt.Printf("Average %0.1f seconds per frame.\n", totalTime/float64(totalFrames))
-	}
-}
-
-// displays textual progress every 500ms
-func showProgress(progress *float64) {
-	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		for *progress < 1.0 {
-			select {
-			case <-ticker.C:
-				// the ansi escape code here moves the cursor left 100 characters
-				fmt.Printf("\u001b[100D %0.1f%% complete.", *progress*100)
-			default:
-			}
+		if framesMade > 0 {
+			fmt.Printf("Average %0.1f seconds per frame.\n", totalTime/float64(framesMade))
 		}
-		ticker.Stop()
-	}()
+	}
 }
 
-// could just be done with a formula, probably
-func totalFrames(zoomFactor, finalWidth float64) (frames int) {
-	for curZoom := 4.0; curZoom >= finalWidth; frames++ {
-		curZoom = 4.0 / math.Pow(zoomFactor, float64(frames))
-	}
-	return
+// the number of frames needed to zoom from startWidth to finalWidth
+func totalFrames(startWidth, zoomFactor float64, finalWidth *big.Float) int {
+	mant := new(big.Float)
+	exp := finalWidth.MantExp(mant)
+	m, _ := mant.Float64()
+	logFinal := math.Log(m) + float64(exp)*math.Ln2
+	return int(math.Floor((math.Log(startWidth)-logFinal)/math.Log(zoomFactor))) + 1
 }
 
 // Create a directory for the program output from the filename
 // provided (eg in Config.ImageFile). Creates directories if they don't exist.
-func makeOutputDir(base string) string {
+func makeOutputDir(base string) (string, error) {
 	dir, file := filepath.Split(base)
 	file = strings.Split(file, ".")[0]
 	path := filepath.Join(dir, file+"_zoom")
-	err := os.MkdirAll(path, 0755)
-	if err != nil {
-		panic(err)
+	return path, os.MkdirAll(path, 0755)
+}
+
+// writeFrame writes img to filename, by way of a temporary file so that an
+// interruption never leaves a partial frame which -resume would skip.
+func writeFrame(img image.Image, filename string, meta *m.Metadata) error {
+	ext := filepath.Ext(filename)
+	tmp := strings.TrimSuffix(filename, ext) + ".part" + ext
+	if err := m.WriteImage(img, tmp, meta.Config.Quality, meta); err != nil {
+		return err
 	}
-	return path
+	return os.Rename(tmp, filename)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	m "mandelbrot"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs zoomvid itself when the test runs it as a command.
+func TestMain(t *testing.M) {
+	if os.Getenv("ZOOMVID_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(t.Run())
+}
+
+// run runs zoomvid with args, failing the test if it fails.
+func run(t *testing.T, args ...string) {
+	t.Helper()
+	c := exec.Command(os.Args[0], args...)
+	c.Env = append(os.Environ(), "ZOOMVID_TEST_MAIN=1")
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("zoomvid %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestDensity(t *testing.T) {
+	dir := t.TempDir()
+	ramp := filepath.Join(dir, "ramp.css")
+	if err := ioutil.WriteFile(ramp, []byte("linear-gradient(#000, #fff)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := m.NewConfig()
+	cfg.XRes, cfg.YRes = 16, 16
+	cfg.PlotWidth, cfg.PlotHeight = 2, 2
+	cfg.Iterations = 50
+	cfg.Density, cfg.Samples = m.DensityBuddhabrot, 4
+	cfg.RampFile = ramp
+	cfg.DataFile = filepath.Join(dir, "out.dat")
+	cfg.ImageFile = filepath.Join(dir, "out.png")
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(config, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// zooming from 4 to 2 makes two frames, and Buddhabrots leave no
+	// checkpoint to remove after each
+	run(t, "-config", config, "-width", "4", "-zoom", "2")
+	for _, frame := range []string{"0000000000.png", "0000000001.png"} {
+		if _, err := os.Stat(filepath.Join(dir, "out_zoom", frame)); err != nil {
+			t.Error(err)
+		}
+	}
+}
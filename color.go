@@ -1,81 +1,273 @@
 package mandelbrot
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"image/color"
 	"io/ioutil"
+	"math"
 )
 
-// Stop represents a color stop and its position within a color ramp.
+// Stop represents a color stop and its position within a color ramp. Space,
+// Hue and Easing choose how the colors are interpolated from this stop to the
+// next, so they are ignored on the last stop; "" is SpaceSRGB, HueShorter and
+// EaseLinear.
 type Stop struct {
 	Position int    `json:"position,omitempty"`
 	Color    string `json:"color,omitempty"`
+	Space    string `json:"space,omitempty"`  // see the Space* constants
+	Hue      string `json:"hue,omitempty"`    // see the Hue* constants
+	Easing   string `json:"easing,omitempty"` // see the Ease* constants
 }
 
 // RGBA converts the `Stop` to `color.RGBA`.
-func (s Stop) RGBA() color.RGBA {
+func (s Stop) RGBA() (color.RGBA, error) {
 	return HexToRGBA(s.Color)
 }
 
 // HexToRGBA converts a hex string in the form "RRGGBB" to a color.RGBA.
-// The alpha component is always 255 (opaque).
-func HexToRGBA(hexColor string) color.RGBA {
+// The alpha component is always 255 (opaque). The error wraps ErrBadColor.
+func HexToRGBA(hexColor string) (color.RGBA, error) {
 	components, err := hex.DecodeString(hexColor)
-	if err != nil {
-		panic(err)
+	if err != nil || len(components) != 3 {
+		return color.RGBA{}, fmt.Errorf("%w '%s'", ErrBadColor, hexColor)
 	}
 	return color.RGBA{
 		R: components[0],
 		G: components[1],
 		B: components[2],
-		A: 255}
+		A: 255}, nil
 }
 
-// ReadStops reads and returns the list of `Stop` from the json file.
-func ReadStops(filename string) []Stop {
+// ReadStops reads and returns the list of `Stop` from the json file. The file
+// holds either the list, or an object with the list as "stops" and "space",
+// "hue" and "easing" for the stops which don't set their own.
+func ReadStops(filename string) ([]Stop, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	var stops []Stop
-	err = json.Unmarshal(data, &stops)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var file struct {
+			Space  string `json:"space"`
+			Hue    string `json:"hue"`
+			Easing string `json:"easing"`
+			Stops  []Stop `json:"stops"`
+		}
+		err = json.Unmarshal(data, &file)
+		for i := range file.Stops {
+			s := &file.Stops[i]
+			if s.Space == "" {
+				s.Space = file.Space
+			}
+			if s.Hue == "" {
+				s.Hue = file.Hue
+			}
+			if s.Easing == "" {
+				s.Easing = file.Easing
+			}
+		}
+		stops = file.Stops
+	} else {
+		err = json.Unmarshal(data, &stops)
+	}
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("reading stops from %s: %w", filename, err)
 	}
 
-	return stops
+	return stops, nil
 }
 
-// MakeRamp uses a list of `Stop` to create a color ramp.
-func MakeRamp(stops []Stop) (ramp []color.RGBA) {
+// MakeRamp uses a list of `Stop` to create a color ramp. There must be at
+// least 2 stops, in order of increasing position starting at 0, or the
+// error wraps ErrBadRamp, as it does for an unknown Space, Hue or Easing.
+// The entries at the stops' positions are exactly their colors.
+func MakeRamp(stops []Stop) (ramp []color.RGBA, err error) {
+	if len(stops) < 2 {
+		return nil, fmt.Errorf("%w: need at least 2 stops, have %d", ErrBadRamp, len(stops))
+	}
+	if stops[0].Position != 0 {
+		return nil, fmt.Errorf("%w: first stop is at %d, not 0", ErrBadRamp, stops[0].Position)
+	}
+
+	colors := make([]color.RGBA, len(stops))
+	for is, s := range stops {
+		if is > 0 && s.Position <= stops[is-1].Position {
+			return nil, fmt.Errorf("%w: stop at %d follows stop at %d", ErrBadRamp, s.Position, stops[is-1].Position)
+		}
+		if colors[is], err = s.RGBA(); err != nil {
+			return nil, err
+		}
+	}
+
 	for is := 0; is < len(stops)-1; is++ {
-		cur, next := stops[is], stops[is+1]
-
-		// calculate various parameters
-		between := (next.Position - 1) - cur.Position
-		nc, cc := next.RGBA(), cur.RGBA()
-		dR := round((float64(nc.R) - float64(cc.R)) / float64(between+1))
-		dG := round((float64(nc.G) - float64(cc.G)) / float64(between+1))
-		dB := round((float64(nc.B) - float64(cc.B)) / float64(between+1))
-
-		// do interpolation between stops
-		for i := 0; i <= between; i++ {
-			ramp = append(ramp,
-				color.RGBA{
-					R: uint8(int(cc.R) + i*dR),
-					G: uint8(int(cc.G) + i*dG),
-					B: uint8(int(cc.B) + i*dB),
-					A: 255})
+		seg, err := newSegment(stops, colors, is)
+		if err != nil {
+			return nil, err
+		}
+		ramp = append(ramp, colors[is])
+		for p := stops[is].Position + 1; p < stops[is+1].Position; p++ {
+			ramp = append(ramp, seg.at(float64(p)))
 		}
+	}
+
+	// add final stop color to finish ramp
+	return append(ramp, colors[len(colors)-1]), nil
+}
+
+// segment interpolates the colors between two stops of a ramp.
+type segment struct {
+	space
+	spline bool
+	cubic  bool
+	x0, x1 float64
+	p0, p1 coords // the colors at x0 and x1
+	m0, m1 coords // the slopes of a spline at x0 and x1
+}
+
+// newSegment gets the segment from stop i to the next, whose colors have
+// been parsed.
+func newSegment(stops []Stop, colors []color.RGBA, i int) (*segment, error) {
+	cur := stops[i]
+	sp, err := getSpace(cur.Space)
+	if err != nil {
+		return nil, err
+	}
+	switch cur.Hue {
+	case "", HueShorter, HueLonger, HueIncreasing, HueDecreasing:
+	default:
+		return nil, fmt.Errorf("%w: hue direction '%s' is unknown", ErrBadRamp, cur.Hue)
+	}
+	s := &segment{space: sp, x0: float64(cur.Position), x1: float64(stops[i+1].Position)}
+	switch cur.Easing {
+	case "", EaseLinear:
+	case EaseCubic:
+		s.cubic = true
+	case EaseSpline:
+		s.spline = true
+	default:
+		return nil, fmt.Errorf("%w: easing '%s' is unknown", ErrBadRamp, cur.Easing)
+	}
 
-		// add final stop color to finish ramp
-		if is == len(stops)-2 {
-			ramp = append(ramp, nc)
+	point := func(j int) coords { return sp.to(rgbCoords(colors[j])) }
+	s.p0, s.p1 = point(i), point(i+1)
+	if s.hue >= 0 && s.p0[s.sat] < achromatic {
+		s.p0[s.hue] = s.p1[s.hue]
+	}
+	s.p1 = s.follow(s.p0, s.p1, cur.Hue)
+	if !s.spline {
+		return s, nil
+	}
+
+	// a Catmull-Rom spline, whose slope at each stop is that of the line
+	// between its neighbours; at the ends, that of the segment
+	slope := func(a, b coords, xa, xb float64) (m coords) {
+		for k := range m {
+			m[k] = (b[k] - a[k]) / (xb - xa)
 		}
+		return m
+	}
+	s.m0 = slope(s.p0, s.p1, s.x0, s.x1)
+	s.m1 = s.m0
+	if i > 0 {
+		prev := s.follow(s.p0, point(i-1), HueShorter)
+		s.m0 = slope(prev, s.p1, float64(stops[i-1].Position), s.x1)
+	}
+	if i+2 < len(stops) {
+		next := s.follow(s.p1, point(i+2), HueShorter)
+		s.m1 = slope(s.p0, next, s.x0, float64(stops[i+2].Position))
+	}
+	return s, nil
+}
+
+// achromatic is the saturation or chroma below which a color is gray, so its
+// hue is meaningless and it takes that of the color it is interpolated with.
+const achromatic = 1e-4
+
+// follow returns b with its hue, if the space has one, adjusted to be
+// reached from a in the given direction.
+func (s *segment) follow(a, b coords, direction string) coords {
+	if s.hue < 0 {
+		return b
+	}
+	if b[s.sat] < achromatic {
+		b[s.hue] = a[s.hue]
+	} else if a[s.sat] >= achromatic {
+		b[s.hue] = unwrap(a[s.hue], b[s.hue], direction)
+	}
+	return b
+}
+
+// at gets the color at position x within the segment.
+func (s *segment) at(x float64) color.RGBA {
+	h := s.x1 - s.x0
+	t := (x - s.x0) / h
+	if s.cubic {
+		t = t * t * (3 - 2*t)
 	}
+	var c coords
+	for k := range c {
+		if s.spline {
+			// the cubic Hermite basis
+			t2, t3 := t*t, t*t*t
+			c[k] = (2*t3-3*t2+1)*s.p0[k] + (t3-2*t2+t)*h*s.m0[k] +
+				(3*t2-2*t3)*s.p1[k] + (t3-t2)*h*s.m1[k]
+		} else {
+			c[k] = s.p0[k] + t*(s.p1[k]-s.p0[k])
+		}
+	}
+	return rgbColor(s.from(c))
+}
+
+// RampColor gets the color at the fractional index v of the ramp, which
+// wraps around like the integer index in CreatePicture, interpolating between
+// the neighbouring entries.
+func RampColor(ramp []color.RGBA, v float64) color.RGBA {
+	n := float64(len(ramp))
+	v = math.Mod(v, n)
+	if v < 0 {
+		v += n
+	}
+	i := int(v)
+	f := v - float64(i)
+	a, b := ramp[i%len(ramp)], ramp[(i+1)%len(ramp)]
+	return color.RGBA{
+		R: lerp8(a.R, b.R, f),
+		G: lerp8(a.G, b.G, f),
+		B: lerp8(a.B, b.B, f),
+		A: lerp8(a.A, b.A, f)}
+}
+
+// RampColor64 gets the color at the fractional index v of the ramp like
+// RampColor, but with 16 bits per channel, so that the colors between the
+// ramp's entries aren't rounded to its 8 bits.
+func RampColor64(ramp []color.RGBA, v float64) color.RGBA64 {
+	n := float64(len(ramp))
+	v = math.Mod(v, n)
+	if v < 0 {
+		v += n
+	}
+	i := int(v)
+	f := v - float64(i)
+	a, b := rgba64(ramp[i%len(ramp)]), rgba64(ramp[(i+1)%len(ramp)])
+	return color.RGBA64{
+		R: lerp16(a.R, b.R, f),
+		G: lerp16(a.G, b.G, f),
+		B: lerp16(a.B, b.B, f),
+		A: lerp16(a.A, b.A, f)}
+}
+
+// lerp8 linearly interpolates between a and b.
+func lerp8(a, b uint8, f float64) uint8 {
+	return uint8(round(float64(a) + f*(float64(b)-float64(a))))
+}
 
-	return
+// lerp16 linearly interpolates between a and b.
+func lerp16(a, b uint16, f float64) uint16 {
+	return uint16(round(float64(a) + f*(float64(b)-float64(a))))
 }
 
 // utility function to round floats to ints, since golang is so
@@ -1,7 +1,10 @@
 package mandelbrot
 
 import (
+	"errors"
 	"image/color"
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -20,8 +23,8 @@ func TestMakeRamp(t *testing.T) {
 			name: "16 b-w greyscale",
 			args: args{
 				[]Stop{
-					Stop{0, "000000"},
-					Stop{16, "FFFFFF"}},
+					{Position: 0, Color: "000000"},
+					{Position: 16, Color: "FFFFFF"}},
 				// 16},
 			},
 			wantLen: 17},
@@ -29,9 +32,9 @@ func TestMakeRamp(t *testing.T) {
 			name: "32 w-b-w greyscale",
 			args: args{
 				[]Stop{
-					Stop{0, "FFFFFF"},
-					Stop{16, "000000"},
-					Stop{32, "FFFFFF"}},
+					{Position: 0, Color: "FFFFFF"},
+					{Position: 16, Color: "000000"},
+					{Position: 32, Color: "FFFFFF"}},
 				// 32},
 			},
 			wantLen: 33},
@@ -39,9 +42,9 @@ func TestMakeRamp(t *testing.T) {
 			name: "128 r-g-b",
 			args: args{
 				[]Stop{
-					Stop{0, "FF0000"},
-					Stop{64, "00FF00"},
-					Stop{128, "0000FF"}},
+					{Position: 0, Color: "FF0000"},
+					{Position: 64, Color: "00FF00"},
+					{Position: 128, Color: "0000FF"}},
 				// 128},
 			},
 			wantLen: 129},
@@ -49,8 +52,8 @@ func TestMakeRamp(t *testing.T) {
 			name: "r-b 32 color",
 			args: args{
 				[]Stop{
-					Stop{0, "FF0000"},
-					Stop{32, "0000FF"}},
+					{Position: 0, Color: "FF0000"},
+					{Position: 32, Color: "0000FF"}},
 				// 64},
 			},
 			wantLen: 33},
@@ -58,8 +61,8 @@ func TestMakeRamp(t *testing.T) {
 			name: "2 b-w",
 			args: args{
 				[]Stop{
-					Stop{0, "000000"},
-					Stop{1, "FFFFFF"}},
+					{Position: 0, Color: "000000"},
+					{Position: 1, Color: "FFFFFF"}},
 				// 20},
 			},
 			wantLen: 2},
@@ -67,16 +70,19 @@ func TestMakeRamp(t *testing.T) {
 			name: "256 grayscale w-b-w",
 			args: args{
 				[]Stop{
-					Stop{0, "FFFFFF"},
-					Stop{128, "000000"},
-					Stop{256, "FFFFFF"}},
+					{Position: 0, Color: "FFFFFF"},
+					{Position: 128, Color: "000000"},
+					{Position: 256, Color: "FFFFFF"}},
 				// 20},
 			},
 			wantLen: 257},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotRamp := MakeRamp(tt.args.stops /*, tt.args.maxIteration*/)
+			gotRamp, err := MakeRamp(tt.args.stops /*, tt.args.maxIteration*/)
+			if err != nil {
+				t.Fatal(err)
+			}
 			t.Log(gotRamp)
 
 			// test length
@@ -86,7 +92,7 @@ func TestMakeRamp(t *testing.T) {
 
 			// test that stops are in the correct positions (indices) in the ramp
 			for _, s := range tt.args.stops {
-				if s.RGBA() != gotRamp[s.Position] {
+				if c, _ := s.RGBA(); c != gotRamp[s.Position] {
 					t.Errorf("MakeRamp(): stop %v != gotRamp[%d] (%v)", s, s.Position, gotRamp[s.Position])
 				}
 			}
@@ -110,9 +116,135 @@ func TestHexToRGBA(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := HexToRGBA(tt.args.hexColor); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("HexToRGBA() = %v, want %v", got, tt.want)
+			if got, err := HexToRGBA(tt.args.hexColor); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HexToRGBA() = %v, %v, want %v", got, err, tt.want)
 			}
 		})
 	}
+
+	for _, bad := range []string{"", "00000", "0000FF00", "GG0000", "#FFFFFF"} {
+		if _, err := HexToRGBA(bad); !errors.Is(err, ErrBadColor) {
+			t.Errorf("HexToRGBA(%q) error = %v, want ErrBadColor", bad, err)
+		}
+	}
+}
+
+func TestMakeRampErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		stops []Stop
+		want  error
+	}{
+		{"no stops", nil, ErrBadRamp},
+		{"one stop", []Stop{{Position: 0, Color: "000000"}}, ErrBadRamp},
+		{"not at 0", []Stop{{Position: 1, Color: "000000"}, {Position: 4, Color: "FFFFFF"}}, ErrBadRamp},
+		{"out of order", []Stop{{Position: 0, Color: "000000"}, {Position: 8, Color: "FFFFFF"}, {Position: 4, Color: "FF0000"}}, ErrBadRamp},
+		{"bad color", []Stop{{Position: 0, Color: "000000"}, {Position: 8, Color: "white"}}, ErrBadColor},
+		{"bad space", []Stop{{Position: 0, Color: "000000", Space: "cmyk"}, {Position: 8, Color: "FFFFFF"}}, ErrBadRamp},
+		{"bad hue", []Stop{{Position: 0, Color: "000000", Space: SpaceHSV, Hue: "up"}, {Position: 8, Color: "FFFFFF"}}, ErrBadRamp},
+		{"bad easing", []Stop{{Position: 0, Color: "000000", Easing: "bounce"}, {Position: 8, Color: "FFFFFF"}}, ErrBadRamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MakeRamp(tt.stops); !errors.Is(err, tt.want) {
+				t.Errorf("MakeRamp() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRampColor(t *testing.T) {
+	ramp := []color.RGBA{{0, 0, 0, 255}, {200, 100, 50, 255}}
+	tests := []struct {
+		name string
+		v    float64
+		want color.RGBA
+	}{
+		{"first", 0, color.RGBA{0, 0, 0, 255}},
+		{"halfway", 0.5, color.RGBA{100, 50, 25, 255}},
+		{"second", 1, color.RGBA{200, 100, 50, 255}},
+		{"wraps", 1.5, color.RGBA{100, 50, 25, 255}},
+		{"negative", -1, color.RGBA{200, 100, 50, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RampColor(ramp, tt.v); got != tt.want {
+				t.Errorf("RampColor(%v) = %v, want %v", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeRampInterpolation(t *testing.T) {
+	gray := func(v uint8) color.RGBA { return color.RGBA{v, v, v, 255} }
+	tests := []struct {
+		name  string
+		stops []Stop
+		at    int
+		want  color.RGBA
+	}{
+		{"srgb", []Stop{{Color: "000000"}, {Position: 16, Color: "FFFFFF"}}, 4, gray(64)},
+		{"linear rgb", []Stop{{Color: "000000", Space: SpaceLinearRGB}, {Position: 2, Color: "FFFFFF"}}, 1, gray(188)},
+		{"oklab", []Stop{{Color: "000000", Space: SpaceOKLab}, {Position: 2, Color: "FFFFFF"}}, 1, gray(99)},
+		{"hcl gray", []Stop{{Color: "000000", Space: SpaceHCL}, {Position: 2, Color: "FFFFFF"}}, 1, gray(119)},
+		{"hsv shorter", []Stop{{Color: "FF0000", Space: SpaceHSV}, {Position: 2, Color: "0000FF"}}, 1, color.RGBA{255, 0, 255, 255}},
+		{"hsv longer", []Stop{{Color: "FF0000", Space: SpaceHSV, Hue: HueLonger}, {Position: 2, Color: "0000FF"}}, 1, color.RGBA{0, 255, 0, 255}},
+		{"hsv increasing", []Stop{{Color: "FF0000", Space: SpaceHSV, Hue: HueIncreasing}, {Position: 2, Color: "0000FF"}}, 1, color.RGBA{0, 255, 0, 255}},
+		{"hsv decreasing", []Stop{{Color: "0000FF", Space: SpaceHSV, Hue: HueDecreasing}, {Position: 2, Color: "FF0000"}}, 1, color.RGBA{0, 255, 0, 255}},
+		{"hsv from gray", []Stop{{Color: "000000", Space: SpaceHSV}, {Position: 2, Color: "0000FF"}}, 1, color.RGBA{64, 64, 128, 255}},
+		{"cubic", []Stop{{Color: "000000", Easing: EaseCubic}, {Position: 16, Color: "FFFFFF"}}, 4, gray(40)},
+		{"spline on a line", []Stop{{Color: "000000", Easing: EaseSpline}, {Position: 10, Color: "646464", Easing: EaseSpline}, {Position: 20, Color: "C8C8C8"}}, 15, gray(150)},
+		{"spline curves", []Stop{{Color: "000000", Easing: EaseSpline}, {Position: 10, Color: "C8C8C8", Easing: EaseSpline}, {Position: 20, Color: "000000"}}, 5, gray(125)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ramp, err := MakeRamp(tt.stops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ramp[tt.at]; got != tt.want {
+				t.Errorf("ramp[%d] = %v, want %v", tt.at, got, tt.want)
+			}
+			// the stops' colors are exact, whatever the space
+			for _, s := range tt.stops {
+				if c, _ := s.RGBA(); ramp[s.Position] != c {
+					t.Errorf("ramp[%d] = %v, want the stop's %v", s.Position, ramp[s.Position], c)
+				}
+			}
+		})
+	}
+}
+
+func TestColorSpaceRoundTrip(t *testing.T) {
+	colors := []string{"000000", "FFFFFF", "FF0000", "00FF00", "0000FF", "808080", "123456", "FEDCBA", "01FF80"}
+	for name, sp := range spaces {
+		for _, hex := range colors {
+			c, _ := HexToRGBA(hex)
+			if got := rgbColor(sp.from(sp.to(rgbCoords(c)))); got != c {
+				t.Errorf("%s: %s became %v", name, hex, got)
+			}
+		}
+	}
+}
+
+func TestReadStopsObject(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ramp.json")
+	data := `{"space": "oklab", "easing": "spline", "stops": [
+		{"color": "000000"},
+		{"position": 8, "color": "FF0000", "space": "hsv"},
+		{"position": 16, "color": "FFFFFF"}]}`
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stops, err := ReadStops(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Stop{
+		{Color: "000000", Space: SpaceOKLab, Easing: EaseSpline},
+		{Position: 8, Color: "FF0000", Space: SpaceHSV, Easing: EaseSpline},
+		{Position: 16, Color: "FFFFFF", Space: SpaceOKLab, Easing: EaseSpline}}
+	if !reflect.DeepEqual(stops, want) {
+		t.Errorf("ReadStops() = %v, want %v", stops, want)
+	}
 }
@@ -0,0 +1,233 @@
+package mandelbrot
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Color spaces for Stop.Space, in which the colors of a ramp are
+// interpolated.
+const (
+	SpaceSRGB      = "srgb"       // the sRGB components, as in hex colors
+	SpaceLinearRGB = "linear_rgb" // the components without sRGB's gamma, as light mixes
+	SpaceHSV       = "hsv"        // hue, saturation and value, around the color wheel
+	SpaceHCL       = "hcl"        // hue, chroma and lightness of CIELAB, around a perceptual color wheel
+	SpaceOKLab     = "oklab"      // OKLab, in which steps look even, without hue shifts
+)
+
+// Hue directions for Stop.Hue, for the spaces with a hue, which is an angle.
+const (
+	HueShorter    = "shorter"    // the shorter way around
+	HueLonger     = "longer"     // the longer way around
+	HueIncreasing = "increasing" // with the angle increasing, through red, yellow, green...
+	HueDecreasing = "decreasing" // with the angle decreasing, through red, magenta, blue...
+)
+
+// Easing curves for Stop.Easing, which space the colors along a segment of a
+// ramp.
+const (
+	EaseLinear = "linear" // evenly
+	EaseCubic  = "cubic"  // slowly away from each stop and quickly between
+	EaseSpline = "spline" // along a smooth curve through all the stops
+)
+
+// coords are the coordinates of a color in one of the spaces.
+type coords [3]float64
+
+// space converts colors, as sRGB components in [0,1], to and from coords.
+type space struct {
+	to   func(rgb coords) coords
+	from func(c coords) coords
+	hue  int // the index of the hue, which is in degrees, or -1
+	sat  int // the index of the saturation or chroma, with which hue is meaningless if 0
+}
+
+var spaces = map[string]space{
+	SpaceSRGB:      {to: func(c coords) coords { return c }, from: func(c coords) coords { return c }, hue: -1},
+	SpaceLinearRGB: {to: linearRGB, from: fromLinearRGB, hue: -1},
+	SpaceHSV:       {to: hsv, from: fromHSV, hue: 0, sat: 1},
+	SpaceHCL:       {to: hcl, from: fromHCL, hue: 0, sat: 1},
+	SpaceOKLab:     {to: okLab, from: fromOKLab, hue: -1},
+}
+
+// getSpace gets the space with the given name, where "" is SpaceSRGB.
+func getSpace(name string) (space, error) {
+	if name == "" {
+		name = SpaceSRGB
+	}
+	s, ok := spaces[name]
+	if !ok {
+		return s, fmt.Errorf("%w: color space '%s' is unknown", ErrBadRamp, name)
+	}
+	return s, nil
+}
+
+// rgbCoords converts c to sRGB components in [0,1].
+func rgbCoords(c color.RGBA) coords {
+	return coords{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+}
+
+// rgbColor converts sRGB components to an opaque color.RGBA, clipping those
+// out of [0,1], which colors interpolated in the wider spaces can be.
+func rgbColor(c coords) color.RGBA {
+	var rgb [3]uint8
+	for i, v := range c {
+		rgb[i] = uint8(round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}
+}
+
+// unwrap returns the hue h1 adjusted by whole turns to go from h0 in the
+// given direction, so that interpolating between them goes that way.
+func unwrap(h0, h1 float64, direction string) float64 {
+	d := h1 - h0
+	switch direction {
+	case HueLonger:
+		if d > 0 && d < 180 {
+			h1 -= 360
+		} else if d > -180 && d <= 0 {
+			h1 += 360
+		}
+	case HueIncreasing:
+		if d < 0 {
+			h1 += 360
+		}
+	case HueDecreasing:
+		if d > 0 {
+			h1 -= 360
+		}
+	default:
+		if d > 180 {
+			h1 -= 360
+		} else if d < -180 {
+			h1 += 360
+		}
+	}
+	return h1
+}
+
+// The conversions between spaces.
+//
+// Math from
+// https://www.w3.org/TR/css-color-4/#color-conversion-code
+// https://bottosson.github.io/posts/oklab/
+
+func linearRGB(c coords) coords {
+	for i, v := range c {
+		if v <= 0.04045 {
+			c[i] = v / 12.92
+		} else {
+			c[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return c
+}
+
+func fromLinearRGB(c coords) coords {
+	for i, v := range c {
+		if v <= 0.0031308 {
+			c[i] = v * 12.92
+		} else {
+			c[i] = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+	}
+	return c
+}
+
+func hsv(c coords) coords {
+	r, g, b := c[0], c[1], c[2]
+	max, min := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	d := max - min
+	h := 0.0
+	switch {
+	case d == 0:
+	case max == r:
+		h = math.Mod((g-b)/d+6, 6)
+	case max == g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	s := 0.0
+	if max > 0 {
+		s = d / max
+	}
+	return coords{h * 60, s, max}
+}
+
+func fromHSV(c coords) coords {
+	h, s, v := math.Mod(c[0], 360), c[1], c[2]
+	if h < 0 {
+		h += 360
+	}
+	f := func(n float64) float64 {
+		k := math.Mod(n+h/60, 6)
+		return v - v*s*math.Max(0, math.Min(k, math.Min(4-k, 1)))
+	}
+	return coords{f(5), f(3), f(1)}
+}
+
+// d65 is the white point of sRGB, in XYZ.
+var d65 = coords{0.95047, 1, 1.08883}
+
+func hcl(c coords) coords {
+	l := linearRGB(c)
+	xyz := coords{
+		0.4123907992659595*l[0] + 0.357584339383878*l[1] + 0.1804807884018343*l[2],
+		0.21263900587151036*l[0] + 0.715168678767756*l[1] + 0.07219231536073371*l[2],
+		0.01933081871559185*l[0] + 0.11919477979462599*l[1] + 0.9505321522496606*l[2]}
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(xyz[0]/d65[0]), f(xyz[1]/d65[1]), f(xyz[2]/d65[2])
+	L, a, b := 116*fy-16, 500*(fx-fy), 200*(fy-fz)
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return coords{h, math.Hypot(a, b), L}
+}
+
+func fromHCL(c coords) coords {
+	h, C, L := c[0]*math.Pi/180, c[1], c[2]
+	a, b := C*math.Cos(h), C*math.Sin(h)
+	fy := (L + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	f := func(t float64) float64 {
+		if t3 := t * t * t; t3 > 216.0/24389 {
+			return t3
+		}
+		return (116*t - 16) * 27 / 24389
+	}
+	x, y, z := f(fx)*d65[0], f(fy)*d65[1], f(fz)*d65[2]
+	return fromLinearRGB(coords{
+		3.2409699419045226*x - 1.537383177570094*y - 0.4986107602930034*z,
+		-0.9692436362808796*x + 1.8759675015077202*y + 0.04155505740717559*z,
+		0.05563007969699366*x - 0.20397695888897652*y + 1.0569715142428786*z})
+}
+
+func okLab(c coords) coords {
+	l := linearRGB(c)
+	L := math.Cbrt(0.4122214708*l[0] + 0.5363325363*l[1] + 0.0514459929*l[2])
+	M := math.Cbrt(0.2119034982*l[0] + 0.6806995451*l[1] + 0.1073969566*l[2])
+	S := math.Cbrt(0.0883024619*l[0] + 0.2817188376*l[1] + 0.6299787005*l[2])
+	return coords{
+		0.2104542553*L + 0.7936177850*M - 0.0040720468*S,
+		1.9779984951*L - 2.4285922050*M + 0.4505937099*S,
+		0.0259040371*L + 0.7827717662*M - 0.8086757660*S}
+}
+
+func fromOKLab(c coords) coords {
+	L := c[0] + 0.3963377774*c[1] + 0.2158037573*c[2]
+	M := c[0] - 0.1055613458*c[1] - 0.0638541728*c[2]
+	S := c[0] - 0.0894841775*c[1] - 1.2914855480*c[2]
+	L, M, S = L*L*L, M*M*M, S*S*S
+	return fromLinearRGB(coords{
+		4.0767416621*L - 3.3077115913*M + 0.2309699292*S,
+		-1.2684380046*L + 2.6097574011*M - 0.3413193965*S,
+		-0.0041960863*L - 0.7034186147*M + 1.7076147010*S})
+}
@@ -4,8 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mandelbrot/big"
+	"math"
+	stdbig "math/big"
+	"math/cmplx"
+	"reflect"
+	"strings"
 )
 
+// guardDigits is the number of decimal digits of precision used beyond what
+// is needed to tell neighbouring pixels apart.
+const guardDigits = 20
+
+// minPixelWidth is the narrowest a pixel can be (relative to the magnitude of
+// the plot center) before complex128 math produces visibly blocky images.
+const minPixelWidth = 1e-13
+
 // Config is configuration info for the program, loaded from file.
 type Config struct {
 	CenterReal float64 `json:"center_real"`
@@ -21,6 +35,92 @@ type Config struct {
 	SetColor   string  `json:"set_color"`
 	JuliaReal  float64 `json:"julia_real"`
 	JuliaImag  float64 `json:"julia_imag"`
+	Perturb    bool    `json:"perturb"`
+	// MaxReferences limits how many reference orbits perturbation may use
+	// to fix glitches. 0 uses a reasonable default.
+	MaxReferences int `json:"max_references"`
+	// SeriesTerms is the number of terms of the series approximation used
+	// to skip early iterations with perturbation. 0 disables it.
+	SeriesTerms int `json:"series_terms"`
+	// BigCenterReal, BigCenterImag and BigPlotWidth are decimal strings of
+	// any length, which take precedence over CenterReal, CenterImag and
+	// PlotWidth when set. They are how deep zoom locations are specified.
+	BigCenterReal string `json:"big_center_real,omitempty"`
+	BigCenterImag string `json:"big_center_imag,omitempty"`
+	BigPlotWidth  string `json:"big_plot_width,omitempty"`
+	// EscapeRadius is the |z| beyond which a point is considered to have
+	// escaped. Larger values give more accurate smooth coloring. 0 uses
+	// DefaultEscapeRadius.
+	EscapeRadius float64 `json:"escape_radius"`
+	// Coloring selects how Colorize colors points. See the Color*
+	// constants.
+	Coloring string `json:"coloring"`
+	// Interior enables detecting points in the set without using all the
+	// iterations, by checking for the main cardioid and period 2 bulb and
+	// for periodic orbits.
+	Interior bool `json:"interior"`
+	// Formula selects the fractal; see the Formula* constants. "" is
+	// FormulaMandelbrot. Power is the exponent of z in it, where 0 is 2.
+	// Only the Mandelbrot set can be perturbed, so other formulas, like
+	// Expression, Newton fractals, Julia sets and Traps, can't be plotted
+	// deeper than complex128 can tell the pixels apart; see
+	// UsePerturbation.
+	Formula string  `json:"formula,omitempty"`
+	Power   float64 `json:"power,omitempty"`
+	// Expression is a formula for z' in terms of z and c, such as
+	// "z^3 - z + c", used instead of Formula when set. Bailout is its
+	// condition for escaping, if not |z| > EscapeRadius. See Expression.
+	Expression string `json:"expression,omitempty"`
+	Bailout    string `json:"bailout,omitempty"`
+	// Roots or Coefficients (from the highest power of z down) make the
+	// plot a Newton fractal of the polynomial with them, instead of any
+	// Formula or Expression. Each is a complex number in the syntax of
+	// Expression, such as "-0.5 + 0.866i" or "exp(2i*pi/3)". Relaxation
+	// multiplies the steps of Newton's method; 0 is 1. See Newton.
+	Roots        []string `json:"roots,omitempty"`
+	Coefficients []string `json:"coefficients,omitempty"`
+	Relaxation   float64  `json:"relaxation,omitempty"`
+	// RampFiles are the ramps used in turn for the basins of the roots with
+	// ColorBasin. Without them, the ramp in RampFile is divided between the
+	// roots.
+	RampFiles []string `json:"ramp_files,omitempty"`
+	// Density, if set, makes the plot a Buddhabrot: how often the orbits of
+	// Samples random points pass through each pixel, rather than the set
+	// itself. See the Density* constants. Bands are the iterations for each
+	// of the red, green and blue of a Nebulabrot; without them there is one
+	// band of Iterations. The same Seed gives the same plot. 0 Samples is
+	// DefaultSamples for every pixel.
+	Density string `json:"density,omitempty"`
+	Samples int    `json:"samples,omitempty"`
+	Seed    int64  `json:"seed,omitempty"`
+	Bands   []int  `json:"bands,omitempty"`
+	// Traps are shapes to which the least distance of each orbit is
+	// measured, for ColorTrap. Plots with them aren't perturbed, and
+	// Newton fractals and Buddhabrots don't use them.
+	Traps []Trap `json:"traps,omitempty"`
+	// Quality is that of JPEG images, from 1 to 100; 0 is DefaultQuality.
+	// Depth is the bits per channel of PNG and TIFF images, 8 or 16; 0 is
+	// 8. The format is chosen by the extension of ImageFile; see
+	// ImageFormat.
+	Quality int `json:"quality,omitempty"`
+	Depth   int `json:"depth,omitempty"`
+	// Supersample is the number of samples taken across and down each
+	// pixel, whose colors are averaged in linear light so that fine detail
+	// doesn't alias; 0 or 1 is one sample. SamplePattern places them: see
+	// the Sample* constants. With Adaptive above 0, only the pixels which
+	// differ from a neighbour by more than Adaptive smooth iterations, or
+	// in being in the set, are supersampled. Seed is that of the jitter.
+	Supersample   int     `json:"supersample,omitempty"`
+	SamplePattern string  `json:"sample_pattern,omitempty"`
+	Adaptive      float64 `json:"adaptive,omitempty"`
+	// Fill, if set, computes only the borders of the regions of the plot in
+	// the set, where every point takes all the iterations, and fills their
+	// insides; see the Fill* constants. With FillCheck above 0, every
+	// FillCheck-th pixel across and down what would be filled is computed
+	// too, and the region is divided or traced further if any differ. Plots
+	// with Traps and Buddhabrots aren't filled.
+	Fill      string `json:"fill,omitempty"`
+	FillCheck int    `json:"fill_check,omitempty"`
 }
 
 // DoJulia is a convenince function to determine if the program should
@@ -30,58 +130,468 @@ func (c Config) DoJulia() bool {
 	return c.JuliaReal != 0.0 && c.JuliaImag != 0.0
 }
 
+// UsePerturbation determines if the Mandelbrot set should be computed with
+// perturbation (see Reference). This is true when Perturb is set, or when
+// the pixels are too close together for complex128 to tell apart. Julia sets,
+// formulas other than z^2 + c, and plots with Traps are never perturbed.
+func (c Config) UsePerturbation() bool {
+	if c.DoJulia() || !c.mandelbrot() || len(c.Traps) > 0 {
+		return false
+	}
+	return c.Perturb || c.deep()
+}
+
+// deep reports if the pixels are too close together for complex128 to tell
+// apart.
+func (c Config) deep() bool {
+	scale := math.Max(1, math.Max(math.Abs(c.CenterReal), math.Abs(c.CenterImag)))
+	return c.PlotWidth/float64(c.XRes) < minPixelWidth*scale
+}
+
+// Precision determines the number of bits of precision needed to tell
+// neighbouring pixels apart at the plot's zoom depth.
+func (c Config) Precision() (uint, error) {
+	pixel, err := c.Width(64)
+	if err != nil {
+		return 0, err
+	}
+	pixel.Quo(pixel, stdbig.NewFloat(float64(c.XRes)))
+	center, err := c.Center(64)
+	if err != nil {
+		return 0, err
+	}
+	scale := math.Max(1, cmplx.Abs(center.Complex128()))
+
+	digits := int(math.Ceil(math.Log10(scale)-log10(pixel))) + guardDigits
+	return big.PrecisionRequired(digits), nil
+}
+
+// Center returns the plot center with prec bits of precision, from
+// BigCenterReal and BigCenterImag if they are set. Like Width, Height and
+// Precision, it returns a *ConfigError if they aren't numbers.
+func (c Config) Center(prec uint) (*big.Complex, error) {
+	r, err := parseBig("big_center_real", c.BigCenterReal, c.CenterReal, prec)
+	if err != nil {
+		return nil, err
+	}
+	i, err := parseBig("big_center_imag", c.BigCenterImag, c.CenterImag, prec)
+	if err != nil {
+		return nil, err
+	}
+	return &big.Complex{R: *r, I: *i}, nil
+}
+
+// Width returns the plot width with prec bits of precision, from
+// BigPlotWidth if it is set.
+func (c Config) Width(prec uint) (*stdbig.Float, error) {
+	return parseBig("big_plot_width", c.BigPlotWidth, c.PlotWidth, prec)
+}
+
+// Height returns the plot height with prec bits of precision. When
+// BigPlotWidth is set, it is scaled to keep the aspect ratio of PlotWidth and
+// PlotHeight (or of the image, if those are unusable).
+func (c Config) Height(prec uint) (*stdbig.Float, error) {
+	if c.BigPlotWidth == "" {
+		return new(stdbig.Float).SetPrec(prec).SetFloat64(c.PlotHeight), nil
+	}
+	aspect := float64(c.YRes) / float64(c.XRes)
+	if c.PlotWidth > 0 && c.PlotHeight > 0 {
+		aspect = c.PlotHeight / c.PlotWidth
+	}
+	h, err := c.Width(prec)
+	if err != nil {
+		return nil, err
+	}
+	return h.Mul(h, stdbig.NewFloat(aspect)), nil
+}
+
+// syncBig sets CenterReal, CenterImag, PlotWidth and PlotHeight to the
+// (rounded) values of their Big counterparts, so that code which only needs
+// float64 sees the right plot. Values which aren't numbers are left alone.
+func (c *Config) syncBig() {
+	if center, err := c.Center(64); err == nil {
+		c.CenterReal, c.CenterImag = real(center.Complex128()), imag(center.Complex128())
+	}
+	if c.BigPlotWidth == "" {
+		return
+	}
+	if h, err := c.Height(64); err == nil {
+		c.PlotHeight, _ = h.Float64()
+		w, _ := c.Width(64)
+		c.PlotWidth, _ = w.Float64()
+	}
+}
+
+// parseBig parses s, the value of field, with prec bits of precision, or
+// uses f if s is empty. The error is a *ConfigError if s isn't a number.
+func parseBig(field, s string, f float64, prec uint) (*stdbig.Float, error) {
+	x := new(stdbig.Float).SetPrec(prec)
+	if s == "" {
+		return x.SetFloat64(f), nil
+	}
+	if _, _, err := x.Parse(s, 10); err != nil {
+		return nil, &ConfigError{Field: field, Reason: fmt.Sprintf("is a bad number '%s': %v", s, err)}
+	}
+	return x, nil
+}
+
+// log10 returns the base 10 logarithm of x, even when x is out of the range
+// of float64.
+func log10(x *stdbig.Float) float64 {
+	mant := new(stdbig.Float)
+	exp := x.MantExp(mant)
+	m, _ := mant.Float64()
+	return math.Log10(m) + float64(exp)*math.Log10(2)
+}
+
+// Params gets the Params for iterating the points of the plot. The error is
+// that of GetNewton, GetExpression, GetFormula or NewTraps, if the fields they
+// read are bad.
+func (c Config) Params() (*Params, error) {
+	// a cycle can't be resolved any more finely than the plot's pixels
+	pixel := c.PlotWidth / float64(c.XRes)
+	p := &Params{
+		EscapeRadius:  c.EscapeRadius,
+		Julia:         c.DoJulia(),
+		C:             c.GetJulia(),
+		Interior:      c.Interior,
+		PeriodEpsilon: math.Min(DefaultPeriodEpsilon, pixel*1e-6)}
+	n, err := c.GetNewton()
+	if err != nil {
+		return nil, err
+	}
+	e, err := c.GetExpression()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.GetFormula()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n != nil:
+		p.Newton = n
+	case e != nil:
+		p.Expression = e
+	case !f.mandelbrot():
+		p.Formula = f
+	}
+	if p.Traps, err = NewTraps(c.Traps); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WithColors returns c with the settings for files and colors of o, which
+// don't change the Grid it produces, so that a Grid can be colored again.
+func (c Config) WithColors(o Config) Config {
+	c.RampFile, c.RampFiles, c.DataFile, c.ImageFile = o.RampFile, o.RampFiles, o.DataFile, o.ImageFile
+	c.SetColor, c.Coloring, c.Quality, c.Depth = o.SetColor, o.Coloring, o.Quality, o.Depth
+	return c
+}
+
+// samePlot reports if c and o produce the same Grid, ignoring the settings
+// for files and colors.
+func (c Config) samePlot(o Config) bool {
+	return reflect.DeepEqual(c.WithColors(Config{}), o.WithColors(Config{}))
+}
+
+// GetFormula gets the Formula selected by Formula and Power.
+func (c Config) GetFormula() (*Formula, error) {
+	return NewFormula(c.Formula, c.Power)
+}
+
+// GetExpression gets the Expression, or nil if there isn't one.
+func (c Config) GetExpression() (*Expression, error) {
+	if c.Expression == "" {
+		return nil, nil
+	}
+	return NewExpression(c.Expression, c.Bailout)
+}
+
+// GetNewton gets the Newton for Roots or Coefficients, or nil if neither is
+// set.
+func (c Config) GetNewton() (*Newton, error) {
+	if len(c.Roots) == 0 && len(c.Coefficients) == 0 {
+		return nil, nil
+	}
+	if len(c.Roots) > 0 && len(c.Coefficients) > 0 {
+		return nil, &ConfigError{Field: "roots", Reason: "can't be used with coefficients"}
+	}
+
+	field, values := "roots", c.Roots
+	if len(c.Coefficients) > 0 {
+		field, values = "coefficients", c.Coefficients
+	}
+	zs := make([]complex128, len(values))
+	for i, v := range values {
+		z, err := parseComplex(v)
+		if err != nil {
+			return nil, &ConfigError{Field: field, Reason: fmt.Sprintf("'%s' is bad: %v", v, err)}
+		}
+		zs[i] = z
+	}
+	if field == "roots" {
+		return NewNewtonRoots(zs, c.Relaxation)
+	}
+	return NewNewton(zs, c.Relaxation)
+}
+
+// mandelbrot reports if the formula is z^2 + c.
+func (c Config) mandelbrot() bool {
+	return c.Expression == "" && len(c.Roots) == 0 && len(c.Coefficients) == 0 &&
+		(c.Formula == "" || c.Formula == FormulaMandelbrot) && (c.Power == 0 || c.Power == 2)
+}
+
+// bands gets the Bands of a Buddhabrot, which are Iterations if there are
+// none.
+func (c Config) bands() []int {
+	if len(c.Bands) == 0 {
+		return []int{c.Iterations}
+	}
+	return c.Bands
+}
+
 // GetJulia is a convenience function to get the Julia point as a complex128.
 func (c Config) GetJulia() complex128 {
 	return complex(c.JuliaReal, c.JuliaImag)
 }
 
 func (c Config) String() string {
-	f := "Plot center:\t%0.8e, %0.8e\nPlot W, H:\t%0.8e, %0.8e\nImage size:\t%dx%d\nIterations:\t%d\nJulia c =\t%0.8e + %0.8ei\nRamp file:\t%s\nData file:\t%s\nImage file:\t%s"
-	return fmt.Sprintf(f, c.CenterReal, c.CenterImag, c.PlotWidth, c.PlotHeight, c.XRes, c.YRes, c.Iterations, c.JuliaReal, c.JuliaImag, c.RampFile, c.DataFile, c.ImageFile)
+	center, width := fmt.Sprintf("%0.8e, %0.8e", c.CenterReal, c.CenterImag), ""
+	if c.BigCenterReal != "" || c.BigCenterImag != "" {
+		center = fmt.Sprintf("%s, %s", c.BigCenterReal, c.BigCenterImag)
+	}
+	if c.BigPlotWidth != "" {
+		width = fmt.Sprintf("\nBig width:\t%s", c.BigPlotWidth)
+	}
+	if c.Supersample > 1 {
+		width += fmt.Sprintf("\nSupersample:\t%dx%d", c.Supersample, c.Supersample)
+		if c.SamplePattern == SampleJitter {
+			width += ", jittered"
+		}
+		if c.Adaptive > 0 {
+			width += fmt.Sprintf(", adaptive at %g", c.Adaptive)
+		}
+	}
+	if c.Fill != "" {
+		width += fmt.Sprintf("\nFill:\t\t%s", c.Fill)
+		if c.FillCheck > 0 {
+			width += fmt.Sprintf(", checking every %d pixels", c.FillCheck)
+		}
+	}
+	if c.Density != "" {
+		width += fmt.Sprintf("\nDensity:\t%s of %d samples, seed %d, bands %v", c.Density, c.Samples, c.Seed, c.bands())
+	}
+	if len(c.Roots) > 0 {
+		width += fmt.Sprintf("\nNewton roots:\t%s", strings.Join(c.Roots, ", "))
+	} else if len(c.Coefficients) > 0 {
+		width += fmt.Sprintf("\nNewton coefs:\t%s", strings.Join(c.Coefficients, ", "))
+	} else if c.Expression != "" {
+		width += fmt.Sprintf("\nFormula:\t%s", c.Expression)
+	} else if f, err := c.GetFormula(); err == nil && !c.mandelbrot() {
+		width += fmt.Sprintf("\nFormula:\t%s, power %g", f.Name, f.Power)
+	}
+	f := "Plot center:\t%s\nPlot W, H:\t%0.8e, %0.8e%s\nImage size:\t%dx%d\nIterations:\t%d\nJulia c =\t%0.8e + %0.8ei\nRamp file:\t%s\nData file:\t%s\nImage file:\t%s"
+	return fmt.Sprintf(f, center, c.PlotWidth, c.PlotHeight, width, c.XRes, c.YRes, c.Iterations, c.JuliaReal, c.JuliaImag, c.RampFile, c.DataFile, c.ImageFile)
 }
 
 // NewConfig gets a Config with reasonable default values.
 func NewConfig() Config {
 	return Config{
-		0.0, 0.0,
-		4.0, 4.0,
-		1000, 1000,
-		512,
-		"ramp.json",
-		"default.gob",
-		"output.jpg",
-		"000000",
-		0.0, 0.0}
+		CenterReal: 0.0,
+		CenterImag: 0.0,
+		PlotWidth:  4.0,
+		PlotHeight: 4.0,
+		XRes:       1000,
+		YRes:       1000,
+		Iterations: 512,
+		RampFile:   "ramp.json",
+		DataFile:   "default.dat",
+		ImageFile:  "output.jpg",
+		SetColor:   "000000",
+		JuliaReal:  0.0,
+		JuliaImag:  0.0,
+		Coloring:   ColorIterations,
+		Interior:   true}
 }
 
-// WriteConfig saves a config to file.
-func WriteConfig(c Config, filename string) {
+// Validate checks that the Config can be used to make a plot. The error is a
+// *ConfigError naming the first field found with a bad value.
+func (c Config) Validate() error {
+	bad := func(field, reason string, args ...interface{}) error {
+		return &ConfigError{Field: field, Reason: fmt.Sprintf(reason, args...)}
+	}
 
-	data, err := json.MarshalIndent(c, "", " ")
-	if err != nil {
-		panic(err)
+	switch {
+	case c.XRes <= 0:
+		return bad("x_res", "must be positive, not %d", c.XRes)
+	case c.YRes <= 0:
+		return bad("y_res", "must be positive, not %d", c.YRes)
+	case c.Iterations <= 0:
+		return bad("iterations", "must be positive, not %d", c.Iterations)
+	case c.BigPlotWidth == "" && !(c.PlotWidth > 0):
+		return bad("plot_width", "must be positive, not %g", c.PlotWidth)
+	case c.BigPlotWidth == "" && !(c.PlotHeight > 0):
+		return bad("plot_height", "must be positive, not %g", c.PlotHeight)
+	case c.EscapeRadius != 0 && !(c.EscapeRadius >= DefaultEscapeRadius):
+		return bad("escape_radius", "must be 0 or at least %g, not %g", DefaultEscapeRadius, c.EscapeRadius)
+	case c.MaxReferences < 0:
+		return bad("max_references", "must not be negative, not %d", c.MaxReferences)
+	case c.SeriesTerms < 0:
+		return bad("series_terms", "must not be negative, not %d", c.SeriesTerms)
 	}
 
-	err = ioutil.WriteFile(filename, data, 664)
+	for _, f := range []struct {
+		field, value string
+	}{
+		{"big_center_real", c.BigCenterReal},
+		{"big_center_imag", c.BigCenterImag},
+		{"big_plot_width", c.BigPlotWidth},
+	} {
+		x, err := parseBig(f.field, f.value, 0, 64)
+		if err != nil {
+			return err
+		}
+		if f.field == "big_plot_width" && f.value != "" && x.Sign() <= 0 {
+			return bad(f.field, "must be positive, not %s", f.value)
+		}
+	}
+
+	if _, err := c.GetFormula(); err != nil {
+		return err
+	}
+	if _, err := c.GetExpression(); err != nil {
+		return err
+	}
+	if _, err := c.GetNewton(); err != nil {
+		return err
+	}
+	if _, err := NewTraps(c.Traps); err != nil {
+		return err
+	}
+	// only the Mandelbrot set is perturbed, and so can be computed deeper
+	// than complex128 allows
+	synced := c
+	synced.syncBig()
+	if c.Density == "" && synced.deep() {
+		field := ""
+		switch {
+		case len(c.Roots) > 0:
+			field = "roots"
+		case len(c.Coefficients) > 0:
+			field = "coefficients"
+		case c.Expression != "":
+			field = "expression"
+		case !c.mandelbrot():
+			field = "formula"
+		case c.DoJulia():
+			field = "julia_real"
+		case len(c.Traps) > 0:
+			field = "traps"
+		}
+		if field != "" {
+			return bad(field, "can't be plotted so deep, where only the Mandelbrot set is perturbed")
+		}
+	}
+
+	switch c.Density {
+	case "":
+	case DensityBuddhabrot, DensityAnti:
+		if c.Expression != "" || len(c.Roots) > 0 || len(c.Coefficients) > 0 {
+			return bad("density", "can't be used with expression, roots or coefficients")
+		}
+	default:
+		return bad("density", "'%s' is unknown", c.Density)
+	}
+	if c.Samples < 0 {
+		return bad("samples", "must not be negative, not %d", c.Samples)
+	}
+	if len(c.Bands) > maxBands {
+		return bad("bands", "must number at most %d, not %d", maxBands, len(c.Bands))
+	}
+	for _, n := range c.Bands {
+		if n <= 0 {
+			return bad("bands", "must be positive, not %d", n)
+		}
+	}
+
+	switch {
+	case c.Supersample < 0 || c.Supersample > maxSupersample:
+		return bad("supersample", "must be from 0 to %d, not %d", maxSupersample, c.Supersample)
+	case c.Supersample > 1 && c.Density != "":
+		return bad("supersample", "can't be used with density")
+	case c.SamplePattern != "" && c.SamplePattern != SampleGrid && c.SamplePattern != SampleJitter:
+		return bad("sample_pattern", "'%s' is unknown", c.SamplePattern)
+	case !(c.Adaptive >= 0) || math.IsInf(c.Adaptive, 1):
+		return bad("adaptive", "must be 0 or positive, not %g", c.Adaptive)
+	}
+
+	switch {
+	case c.Fill != "" && c.Fill != FillRectangles && c.Fill != FillBoundary:
+		return bad("fill", "'%s' is unknown", c.Fill)
+	case c.Fill != "" && (c.Density != "" || len(c.Traps) > 0):
+		return bad("fill", "can't be used with density or traps")
+	case c.FillCheck < 0:
+		return bad("fill_check", "must not be negative, not %d", c.FillCheck)
+	}
+
+	if _, err := HexToRGBA(c.SetColor); err != nil {
+		return bad("set_color", "is a %v", err)
+	}
+
+	format, err := ImageFormat(c.ImageFile)
+	if err != nil && c.ImageFile != "" {
+		return bad("image_file", "is a %v", err)
+	}
+	if c.Quality < 0 || c.Quality > 100 {
+		return bad("quality", "must be from 0 to 100, not %d", c.Quality)
+	}
+	switch {
+	case c.Depth != 0 && c.Depth != 8 && c.Depth != 16:
+		return bad("depth", "must be 0, 8 or 16, not %d", c.Depth)
+	case c.Depth == 16 && format == FormatJPEG:
+		return bad("depth", "must be 8 for JPEG images, not %d", c.Depth)
+	}
+
+	switch c.Coloring {
+	case "", ColorIterations, ColorSmooth, ColorDistance, ColorPeriod, ColorHistogram, ColorHistogramSmooth,
+		ColorBasin, ColorBuddha, ColorTrap:
+	default:
+		return bad("coloring", "'%s' is unknown", c.Coloring)
+	}
+
+	return nil
+}
+
+// WriteConfig saves a config to file.
+func WriteConfig(c Config, filename string) error {
+	data, err := json.MarshalIndent(c, "", " ")
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return ioutil.WriteFile(filename, data, 0664)
 }
 
-// ReadConfig loads a config file.
-func ReadConfig(filename string) (c Config) {
+// ReadConfig loads a config file, and checks it with Validate.
+func ReadConfig(filename string) (Config, error) {
+	var c Config
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		return Config{}, err
 	}
 	err = json.Unmarshal(data, &c)
 	if err != nil {
-		panic(err)
+		return Config{}, fmt.Errorf("reading config %s: %w", filename, err)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, fmt.Errorf("reading config %s: %w", filename, err)
 	}
-	return
+	c.syncBig()
+	return c, nil
 }
 
 // WriteDefault is WriteConfig(NewConfig(), "default.json").
-func WriteDefault() {
-	WriteConfig(NewConfig(), "default.json")
+func WriteDefault() error {
+	return WriteConfig(NewConfig(), "default.json")
 }
@@ -0,0 +1,142 @@
+package mandelbrot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConfigPrecision(t *testing.T) {
+	tests := []struct {
+		name     string
+		width    string
+		xres     int
+		min, max uint
+	}{
+		{"shallow", "4", 1000, 64, 128},
+		{"1e-20", "1e-20", 1000, 128, 192},
+		{"1e-100", "1e-100", 1000, 400, 480},
+		{"beyond float64", "1e-400", 1000, 1390, 1460},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.BigPlotWidth = tt.width
+			cfg.XRes = tt.xres
+			if got, err := cfg.Precision(); err != nil || got < tt.min || got > tt.max {
+				t.Errorf("Precision() = %d, want in [%d, %d]", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(c *Config)
+		field string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"big width only", func(c *Config) { c.PlotWidth, c.PlotHeight, c.BigPlotWidth = 0, 0, "1e-50" }, ""},
+		{"no x_res", func(c *Config) { c.XRes = 0 }, "x_res"},
+		{"negative iterations", func(c *Config) { c.Iterations = -1 }, "iterations"},
+		{"no width", func(c *Config) { c.PlotWidth = 0 }, "plot_width"},
+		{"small escape radius", func(c *Config) { c.EscapeRadius = 1 }, "escape_radius"},
+		{"bad center", func(c *Config) { c.BigCenterImag = "-0.1x" }, "big_center_imag"},
+		{"negative big width", func(c *Config) { c.BigPlotWidth = "-1e-50" }, "big_plot_width"},
+		{"bad set color", func(c *Config) { c.SetColor = "black" }, "set_color"},
+		{"unknown coloring", func(c *Config) { c.Coloring = "rainbow" }, "coloring"},
+		{"16-bit png", func(c *Config) { c.ImageFile, c.Depth = "out.PNG", 16 }, ""},
+		{"unknown image format", func(c *Config) { c.ImageFile = "out.gif" }, "image_file"},
+		{"bad quality", func(c *Config) { c.Quality = 101 }, "quality"},
+		{"bad depth", func(c *Config) { c.ImageFile, c.Depth = "out.tif", 12 }, "depth"},
+		{"16-bit jpeg", func(c *Config) { c.Depth = 16 }, "depth"},
+		{"adaptive jitter", func(c *Config) { c.Supersample, c.SamplePattern, c.Adaptive = 4, SampleJitter, 0.5 }, ""},
+		{"too much supersampling", func(c *Config) { c.Supersample = 17 }, "supersample"},
+		{"supersampled buddhabrot", func(c *Config) { c.Supersample, c.Density = 2, DensityBuddhabrot }, "supersample"},
+		{"unknown sample pattern", func(c *Config) { c.SamplePattern = "poisson" }, "sample_pattern"},
+		{"negative adaptive", func(c *Config) { c.Adaptive = -1 }, "adaptive"},
+		{"checked boundary fill", func(c *Config) { c.Fill, c.FillCheck = FillBoundary, 8 }, ""},
+		{"unknown fill", func(c *Config) { c.Fill = "flood" }, "fill"},
+		{"filled traps", func(c *Config) { c.Fill, c.Traps = FillRectangles, []Trap{{Type: TrapPoint}} }, "fill"},
+		{"negative fill check", func(c *Config) { c.FillCheck = -1 }, "fill_check"},
+		{"deep mandelbrot", func(c *Config) { c.BigPlotWidth = "1e-30" }, ""},
+		{"deep burning ship", func(c *Config) { c.Formula, c.BigPlotWidth = FormulaBurningShip, "1e-30" }, "formula"},
+		{"deep julia", func(c *Config) { c.JuliaReal, c.JuliaImag, c.PlotWidth = -0.8, 0.156, 1e-12 }, "julia_real"},
+		{"deep expression", func(c *Config) { c.Expression, c.BigPlotWidth = "z^2 + c", "1e-20" }, "expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			tt.edit(&cfg)
+			err := cfg.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var ce *ConfigError
+			if !errors.As(err, &ce) || ce.Field != tt.field || !errors.Is(err, ErrConfigInvalid) {
+				t.Errorf("Validate() = %v, want error for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	// unvalidated configs give errors for their bad fields, rather than
+	// panicking or plotting something else
+	tests := []struct {
+		name  string
+		edit  func(c *Config)
+		field string
+	}{
+		{"bad center", func(c *Config) { c.BigCenterImag = "-0.1x" }, "big_center_imag"},
+		{"bad width", func(c *Config) { c.BigPlotWidth = "wide" }, "big_plot_width"},
+		{"bad formula", func(c *Config) { c.Formula = "burning" }, "formula"},
+		{"bad trap", func(c *Config) { c.Traps = []Trap{{Type: TrapCircle}} }, "traps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.XRes, cfg.YRes = 4, 4
+			tt.edit(&cfg)
+			check := func(name string, err error) {
+				var ce *ConfigError
+				if !errors.As(err, &ce) || ce.Field != tt.field {
+					t.Errorf("%s = %v, want error for %s", name, err, tt.field)
+				}
+			}
+			if strings.HasPrefix(tt.field, "big_") {
+				_, err := cfg.Precision()
+				check("Precision()", err)
+				check("InitializeBig()", new(Set).InitializeBig(cfg))
+				return
+			}
+			_, err := cfg.Params()
+			check("Params()", err)
+			check("Initialize()", new(Set).Initialize(cfg))
+		})
+	}
+}
+
+func TestConfigWithColors(t *testing.T) {
+	plot := NewConfig()
+	plot.CenterReal, plot.Iterations = -0.5, 500
+	colors := NewConfig()
+	colors.Coloring, colors.SetColor, colors.Depth = ColorSmooth, "FF0000", 16
+	colors.RampFile, colors.ImageFile = "ramp.json", "out.png"
+
+	got := plot.WithColors(colors)
+	if got.CenterReal != -0.5 || got.Iterations != 500 {
+		t.Errorf("WithColors() plot = %v, %d, want -0.5, 500", got.CenterReal, got.Iterations)
+	}
+	if got.Coloring != ColorSmooth || got.SetColor != "FF0000" || got.Depth != 16 ||
+		got.RampFile != "ramp.json" || got.ImageFile != "out.png" {
+		t.Errorf("WithColors() didn't take the colors: %+v", got)
+	}
+	if !got.samePlot(plot) {
+		t.Error("WithColors() changed the plot")
+	}
+}
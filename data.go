@@ -0,0 +1,358 @@
+package mandelbrot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Data files hold a Grid and the Config that produced it. All numbers are
+// little endian.
+//
+//	magic       [4]byte   "MBRT"
+//	version     uint16    DataVersion
+//	config size uint32
+//	config      []byte    the Config, as json
+//	width       uint32
+//	height      uint32
+//	references  uint32    Grid.References
+//	channels    uint16    number of channels that follow
+//
+// followed by each channel, which is one of the Grid's slices:
+//
+//	id          uint8     see the channel* constants
+//	size        uint64    size of data
+//	data        []byte    the slice's values, zlib compressed
+//
+// Readers skip channels with ids they don't know, so channels can be added
+// without changing the version.
+//
+// Files that don't start with the magic number are taken to be the gob
+// encoded Sets written by older versions of this package.
+
+// DataVersion is the version of the data file format written by WriteData.
+const DataVersion = 1
+
+var dataMagic = [4]byte{'M', 'B', 'R', 'T'}
+
+// maxInflate is about the most that zlib data inflates: 1032 times. It bounds
+// the size of the Grid that the compressed channels of a file can hold, so
+// that a bad file can't make decodeGrid allocate more than its size allows.
+const maxInflate = 1032
+
+// ids of the channels in a data file
+const (
+	channelIterations uint8                    = iota + 1 // []uint32
+	channelSmooth                                         // []float64
+	channelDistance                                       // []float32
+	channelPeriod                                         // []uint32
+	channelFlags                                          // []uint8
+	channelRows                                           // []uint8, 1 for each row done; only in checkpoints
+	channelRoot                                           // []uint8
+	channelHits                                           // []uint32 for the first band; the others follow, to maxBands
+	channelTrap       = channelHits + maxBands            // []float32
+	channelSamples    = channelTrap + 1                   // []uint32, Grid.Samples; the other channels hold the samples after the pixels
+)
+
+// channels maps ids to the Grid's slices.
+func (g *Grid) channels() map[uint8]interface{} {
+	channels := map[uint8]interface{}{
+		channelIterations: g.Iterations,
+		channelSmooth:     g.Smooth,
+		channelDistance:   g.Distance,
+		channelPeriod:     g.Period,
+		channelFlags:      g.Flags,
+		channelRoot:       g.Root,
+		channelTrap:       g.Trap}
+	for b, hits := range g.Hits {
+		channels[channelHits+uint8(b)] = hits
+	}
+	if g.Samples != nil {
+		channels[channelSamples] = g.Samples
+	}
+	return channels
+}
+
+// header is the fixed size part of the data file after the config.
+type header struct {
+	Width, Height, References uint32
+	Channels                  uint16
+}
+
+// EncodeGrid writes g and the cfg that produced it to w in the data file
+// format.
+func EncodeGrid(w io.Writer, g *Grid, cfg Config) error {
+	return encodeGrid(w, g, cfg, nil)
+}
+
+// encodeGrid is EncodeGrid, also writing the extra channels.
+func encodeGrid(w io.Writer, g *Grid, cfg Config, extra map[uint8]interface{}) error {
+	config, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	channels := g.channels()
+	for id, channel := range extra {
+		channels[id] = channel
+	}
+	for _, v := range []interface{}{
+		dataMagic,
+		uint16(DataVersion),
+		uint32(len(config)),
+		config,
+		header{uint32(g.Width), uint32(g.Height), uint32(g.References), uint16(len(channels))},
+	} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	// in id order so that files are reproducible
+	ids := make([]int, 0, len(channels))
+	for id := range channels {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, i := range ids {
+		id := uint8(i)
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		if err := binary.Write(zw, binary.LittleEndian, channels[id]); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, id); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint64(buf.Len())); err != nil {
+			return err
+		}
+		if _, err := buf.WriteTo(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeGrid reads a Grid and the Config that produced it from r, which must
+// be in the data file format.
+func DecodeGrid(r io.Reader) (*Grid, Config, error) {
+	return decodeGrid(r, nil)
+}
+
+// decodeGrid is DecodeGrid, also reading into the channels given by extra
+// for the Grid (once its size is known), if extra is not nil.
+func decodeGrid(r io.Reader, extra func(g *Grid) map[uint8]interface{}) (*Grid, Config, error) {
+	var cfg Config
+	var magic [4]byte
+	var version uint16
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, cfg, err
+	}
+	if magic != dataMagic {
+		return nil, cfg, fmt.Errorf("%w: not a data file", ErrBadData)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, cfg, err
+	}
+	if version > DataVersion {
+		return nil, cfg, fmt.Errorf("%w: version %d is newer than %d", ErrBadData, version, DataVersion)
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, cfg, err
+	}
+	config, err := readBlob(r, uint64(size))
+	if err != nil {
+		return nil, cfg, err
+	}
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, cfg, err
+	}
+	// the Config says what the channels are
+	if err := cfg.Validate(); err != nil {
+		return nil, cfg, fmt.Errorf("%w: %v", ErrBadData, err)
+	}
+
+	var h header
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, cfg, err
+	}
+	// the channels are read before the Grid is made, as their sizes limit
+	// its size, and kept compressed until the Samples are read, as they
+	// give the size of the others
+	blobs := make(map[uint8][]byte, h.Channels)
+	for i := 0; i < int(h.Channels); i++ {
+		var id uint8
+		var size uint64
+		if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return nil, cfg, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, cfg, err
+		}
+		data, err := readBlob(r, size)
+		if err != nil {
+			return nil, cfg, err
+		}
+		blobs[id] = data
+	}
+
+	// every pixel and sample has iterations
+	fits := func(values uint64) bool {
+		return values <= uint64(len(blobs[channelIterations]))*maxInflate/4
+	}
+	if !fits(uint64(h.Width) * uint64(h.Height)) {
+		return nil, cfg, fmt.Errorf("%w: %dx%d grid is larger than its data", ErrBadData, h.Width, h.Height)
+	}
+	g := NewGrid(int(h.Width), int(h.Height))
+	g.References = int(h.References)
+	if cfg.Density != "" {
+		g.makeHits(len(cfg.bands()))
+	}
+
+	if data, ok := blobs[channelSamples]; ok {
+		n := g.Width * g.Height
+		samples := make([]uint32, n+1)
+		if err := readChannel(data, samples); err != nil {
+			return nil, cfg, fmt.Errorf("%w: channel %d: %v", ErrBadData, channelSamples, err)
+		}
+		for i := 0; i < n; i++ {
+			if samples[0] != uint32(n) || samples[i+1] < samples[i] {
+				return nil, cfg, fmt.Errorf("%w: bad samples", ErrBadData)
+			}
+		}
+		if !fits(uint64(samples[n])) {
+			return nil, cfg, fmt.Errorf("%w: %d samples are more than their data", ErrBadData, samples[n]-uint32(n))
+		}
+		g.setSamples(samples)
+	}
+
+	channels := g.channels()
+	if extra != nil {
+		for id, channel := range extra(g) {
+			channels[id] = channel
+		}
+	}
+	for id, data := range blobs {
+		channel, ok := channels[id]
+		if !ok {
+			// from a later version
+			continue
+		}
+		if err := readChannel(data, channel); err != nil {
+			return nil, cfg, fmt.Errorf("%w: channel %d: %v", ErrBadData, id, err)
+		}
+	}
+
+	return g, cfg, nil
+}
+
+// WriteData writes a Grid, and the Config that produced it, to filename in
+// the data file format.
+func WriteData(g *Grid, cfg Config, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := EncodeGrid(w, g, cfg); err != nil {
+		return fmt.Errorf("writing data to %s: %w", filename, err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ReadData reads a Grid, and the Config that produced it, from filename.
+// Legacy data files, which hold a gob encoded Set, are also read, although
+// they have no Config, so a zero Config is returned for them.
+func ReadData(filename string) (*Grid, Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, Config{}, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	if magic, err := r.Peek(len(dataMagic)); err == nil && bytes.Equal(magic, dataMagic[:]) {
+		g, cfg, err := DecodeGrid(r)
+		if err != nil {
+			return nil, Config{}, fmt.Errorf("reading data from %s: %w", filename, err)
+		}
+		return g, cfg, nil
+	}
+
+	coords, err := decodeSet(r)
+	if err != nil {
+		return nil, Config{}, fmt.Errorf("reading data from %s: %w: not a data file or legacy gob (%v)", filename, ErrBadData, err)
+	}
+	return coords.legacyGrid(), Config{}, nil
+}
+
+// readBlob reads size bytes from r, only allocating as much as r holds.
+func readBlob(r io.Reader, size uint64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// readChannel decompresses the data of a channel into channel, ignoring
+// anything after it.
+func readChannel(data []byte, channel interface{}) error {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(zr, binary.LittleEndian, channel); err != nil {
+		return err
+	}
+	return zr.Close()
+}
+
+// decodeSet reads a gob encoded Set, as written by older versions of
+// WriteData.
+func decodeSet(r io.Reader) (coords Set, err error) {
+	gob.Register(&BigJob{})
+	gob.Register(&C128Job{})
+	gob.Register(&PerturbJob{})
+	err = gob.NewDecoder(r).Decode(&coords)
+	return
+}
+
+// legacyGrid converts a Set whose size isn't known to a Grid.
+func (coords Set) legacyGrid() *Grid {
+	width, height := 0, 0
+	for _, j := range coords {
+		_, _, x, y := j.GetImageInfo()
+		if x >= width {
+			width = x + 1
+		}
+		if y >= height {
+			height = y + 1
+		}
+	}
+	return coords.Grid(width, height)
+}
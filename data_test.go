@@ -0,0 +1,113 @@
+package mandelbrot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeGrid(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 31, 17
+	cfg.BigCenterReal = "-0.7436438870371587522977"
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	g.References = 3
+
+	var buf bytes.Buffer
+	if err := EncodeGrid(&buf, g, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, gotCfg, err := DecodeGrid(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, g) {
+		t.Error("DecodeGrid() grid differs from encoded grid")
+	}
+	if !reflect.DeepEqual(gotCfg, cfg) {
+		t.Errorf("DecodeGrid() config = %+v, want %+v", gotCfg, cfg)
+	}
+
+	if _, _, err := DecodeGrid(bytes.NewReader([]byte("not a data file"))); !errors.Is(err, ErrBadData) {
+		t.Errorf("DecodeGrid() of garbage error = %v, want ErrBadData", err)
+	}
+}
+
+func TestDecodeGridBad(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 8, 6
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	encode := func(cfg Config) []byte {
+		var buf bytes.Buffer
+		if err := EncodeGrid(&buf, g, cfg); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	data := encode(cfg)
+	header := 10 + int(binary.LittleEndian.Uint32(data[6:]))
+
+	huge := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(huge[header:], 1<<31)
+	binary.LittleEndian.PutUint32(huge[header+4:], 1<<31)
+	if _, _, err := DecodeGrid(bytes.NewReader(huge)); !errors.Is(err, ErrBadData) {
+		t.Errorf("DecodeGrid() of a huge grid error = %v, want ErrBadData", err)
+	}
+
+	long := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(long[6:], 1<<31)
+	if _, _, err := DecodeGrid(bytes.NewReader(long)); err == nil {
+		t.Error("DecodeGrid() of a config longer than the file succeeded")
+	}
+
+	// more bands than there are channels for
+	cfg.Density, cfg.Bands = DensityBuddhabrot, make([]int, maxBands+1)
+	for b := range cfg.Bands {
+		cfg.Bands[b] = 10
+	}
+	if _, _, err := DecodeGrid(bytes.NewReader(encode(cfg))); !errors.Is(err, ErrBadData) {
+		t.Errorf("DecodeGrid() of %d bands error = %v, want ErrBadData", len(cfg.Bands), err)
+	}
+}
+
+func TestReadDataLegacy(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 12, 9
+	coords, err := NewSet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coords.Calculate(cfg.Iterations)
+
+	// the way WriteData used to write files
+	filename := filepath.Join(t.TempDir(), "legacy.gob")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.Register(&C128Job{})
+	if err := gob.NewEncoder(file).Encode(coords); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, _, err := ReadData(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := coords.Grid(cfg.XRes, cfg.YRes)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("ReadData() of a legacy file differs from the Set")
+	}
+}
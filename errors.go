@@ -0,0 +1,49 @@
+package mandelbrot
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the package. They are usually wrapped with more detail,
+// so test for them with errors.Is.
+var (
+	// ErrBadColor is returned for colors which aren't hex strings in the
+	// form "RRGGBB".
+	ErrBadColor = errors.New("bad color")
+	// ErrBadRamp is returned for color stops which can't make a ramp.
+	ErrBadRamp = errors.New("bad color ramp")
+	// ErrConfigInvalid is returned, as a *ConfigError, for a Config with a
+	// value that can't be used.
+	ErrConfigInvalid = errors.New("invalid config")
+	// ErrBadData is returned for files which aren't in the data file format,
+	// or are of a version that can't be read.
+	ErrBadData = errors.New("bad data file")
+	// ErrCheckpointMismatch is returned when resuming from a checkpoint made
+	// for a different plot.
+	ErrCheckpointMismatch = errors.New("checkpoint is for a different plot")
+	// ErrBadFormat is returned for image files of a format which can't be
+	// written.
+	ErrBadFormat = errors.New("unknown image format")
+	// ErrNoMetadata is returned for images without the Metadata of how
+	// they were made.
+	ErrNoMetadata = errors.New("no metadata in image")
+	// ErrBadExport is returned for export formats which can't be written.
+	ErrBadExport = errors.New("unknown export format")
+)
+
+// ConfigError describes the problem with a field of a Config. Field is the
+// field's name in the config file.
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrConfigInvalid, e.Field, e.Reason)
+}
+
+// Unwrap makes errors.Is(err, ErrConfigInvalid) true for a *ConfigError.
+func (e *ConfigError) Unwrap() error {
+	return ErrConfigInvalid
+}
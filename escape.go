@@ -0,0 +1,235 @@
+package mandelbrot
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// DefaultEscapeRadius is the escape radius used when none is configured.
+// Any point which gets this far from the origin is certain to go to infinity.
+const DefaultEscapeRadius = 2.0
+
+// DefaultPeriodEpsilon is how close z must come to a previous value of its
+// orbit for the point to be considered periodic, and so in the set, when no
+// other value is configured.
+const DefaultPeriodEpsilon = 1e-12
+
+// Params are the settings, other than the number of iterations, which control
+// how the points of a Set are iterated. A Set's jobs share a single Params.
+type Params struct {
+	EscapeRadius  float64     // |z| beyond which a point has escaped
+	Julia         bool        // iterate the Julia set for C instead of Mandelbrot
+	C             complex128  // the Julia set parameter
+	Interior      bool        // detect points in the set without iterating fully
+	PeriodEpsilon float64     // tolerance for periodicity checking
+	Formula       *Formula    // what to iterate; nil is z^2 + c
+	Expression    *Expression // what to iterate instead of Formula, if not nil
+	Newton        *Newton     // Newton's method to do instead, if not nil
+	Traps         Traps       // traps to measure the orbit's distance to
+}
+
+// epsilon returns the periodicity tolerance, allowing for p being nil.
+func (p *Params) epsilon() float64 {
+	if p == nil || p.PeriodEpsilon <= 0 {
+		return DefaultPeriodEpsilon
+	}
+	return p.PeriodEpsilon
+}
+
+// radius returns the escape radius, allowing for p being nil.
+func (p *Params) radius() float64 {
+	if p == nil {
+		return DefaultEscapeRadius
+	}
+	r := p.EscapeRadius
+	if r <= 0 {
+		r = DefaultEscapeRadius
+	}
+	return p.Formula.radius(r)
+}
+
+// Result is the outcome of iterating a single point.
+type Result struct {
+	In         bool    // did not escape within the iterations
+	Iterations int     // number of iterations before becoming unbound
+	Abs        float64 // |z| when the point escaped
+	Smooth     float64 // continuous iteration count, when the point escaped
+	Distance   float64 // estimated distance to the set, when the point escaped
+	Period     int     // period of the orbit, if it was found to be periodic
+	Glitch     bool    // perturbation was unreliable for the point
+	Root       int     // root reached by Newton's method, from 1, or 0
+	Trap       float64 // least distance of the orbit to Params.Traps
+}
+
+// Escape iterates z according to p (z being c for the Mandelbrot set) and
+// returns the Result. A nil p gives the Mandelbrot set with the default
+// escape radius.
+func (p *Params) Escape(z complex128, iterations int) Result {
+	if p == nil {
+		return EscapeMandelbrot(z, iterations, DefaultEscapeRadius)
+	}
+	if p.Julia {
+		return p.escape(z, p.C, iterations, true)
+	}
+	return p.escape(z, z, iterations, false)
+}
+
+// escaped creates the Result for a point which escaped after n iterations
+// with |z|^2 = abs2 and derivative dz.
+func escaped(n int, abs2, radius float64, dz complex128) Result {
+	return escapedPower(n, abs2, radius, 2, dz)
+}
+
+// escapedPower is escaped for a formula which raises z to the given power.
+func escapedPower(n int, abs2, radius, power float64, dz complex128) Result {
+	abs := math.Sqrt(abs2)
+	smooth := SmoothIterations(n, abs, radius)
+	if power != 2 {
+		// the orbit grows like |z|^power rather than |z|^2
+		smooth = float64(n) + 1 - math.Log(math.Log(abs)/math.Log(radius))/math.Log(power)
+	}
+	return Result{
+		Iterations: n,
+		Abs:        abs,
+		Smooth:     smooth,
+		Distance:   DistanceEstimate(abs, cmplx.Abs(dz))}
+}
+
+// SmoothIterations converts the integer iteration count n at which a point
+// escaped, with |z| = abs, to a continuous count in (n, n+1] that does not
+// produce bands when colored. It is normalized so that the result does not
+// depend on the escape radius, although a larger radius is more accurate.
+//
+// Math from
+// https://linas.org/art-gallery/escape/escape.html
+func SmoothIterations(n int, abs, radius float64) float64 {
+	return float64(n) + 1 - math.Log2(math.Log(abs)/math.Log(radius))
+}
+
+// DistanceEstimate estimates the distance from an escaped point to the
+// boundary of the set, from |z| and |dz| when the point escaped. dz is the
+// derivative of z with respect to c for the Mandelbrot set, or with respect
+// to the starting z for Julia sets. The true distance is roughly between the
+// estimate and four times it.
+//
+// Math from
+// https://www.iquilezles.org/www/articles/distancefractals/distancefractals.htm
+func DistanceEstimate(abs, absdz float64) float64 {
+	if absdz == 0 {
+		return math.Inf(1)
+	}
+	return 0.5 * abs * math.Log(abs) / absdz
+}
+
+// EscapeMandelbrot runs the recurrent formula for the Mandelbrot set for the
+// given number of iterations, like IsMemberMandelbrot, but with the given
+// escape radius, and returns the full Result.
+func EscapeMandelbrot(c complex128, iterations int, radius float64) Result {
+	p := Params{EscapeRadius: radius, Interior: true}
+	return p.escape(c, c, iterations, false)
+}
+
+// EscapeJulia runs the recurrent formula for the Julia set for the given
+// number of iterations, like IsMemberJulia, but with the given escape radius,
+// and returns the full Result.
+func EscapeJulia(z, c complex128, iterations int, radius float64) Result {
+	p := Params{EscapeRadius: radius, Interior: true}
+	return p.escape(z, c, iterations, true)
+}
+
+// escape iterates z = z^2 + c, tracking the derivative dz needed for the
+// distance estimate. For the Mandelbrot set this is dz/dc, so
+// dz' = 2z*dz + 1, and for Julia sets it is dz/dz_0, so dz' = 2z*dz.
+func (p *Params) escape(z, c complex128, iterations int, julia bool) Result {
+	if p.Newton != nil {
+		return p.Newton.converge(z, iterations)
+	}
+	if p.Expression != nil {
+		return p.escapeExpression(z, c, iterations, julia)
+	}
+	if !p.Formula.mandelbrot() {
+		return p.escapeFormula(z, c, iterations, julia)
+	}
+	if p.Interior && !julia && p.Traps == nil {
+		// (traps need the orbit)
+		if period := InBulb(c); period > 0 {
+			return Result{In: true, Iterations: iterations, Period: period}
+		}
+	}
+
+	radius := p.radius()
+	r2 := radius * radius
+	dz, dc := complex(1, 0), complex(1, 0)
+	if julia {
+		dc = 0
+	}
+	var cycle periodicity
+	cycle.reset(z, p.epsilon())
+	trap := p.Traps.closest(math.Inf(1), z)
+	for i := 0; i < iterations; i++ {
+		dz = 2*z*dz + dc
+		z = z*z + c
+		trap = p.Traps.closest(trap, z)
+		if a := abs2(z); a > r2 {
+			// went to infinity
+			return p.Traps.trapped(escaped(i, a, radius, dz), trap)
+		}
+		if p.Interior {
+			// the cycle has been all visited, so trap is its least distance
+			if period := cycle.check(z); period > 0 {
+				// caught in a cycle, so it will never go to infinity
+				return p.Traps.trapped(Result{In: true, Iterations: iterations, Period: period}, trap)
+			}
+		}
+	}
+
+	// did not go to "infinity"
+	return p.Traps.trapped(Result{In: true, Iterations: iterations}, trap)
+}
+
+// InBulb checks if c is in the main cardioid or the period 2 bulb of the
+// Mandelbrot set, which together make up most of its area. It returns the
+// period of the component c is in (1 or 2), or 0 if it isn't in either.
+//
+// Math from
+// https://en.wikipedia.org/wiki/Plotting_algorithms_for_the_Mandelbrot_set#Cardioid_/_bulb_checking
+func InBulb(c complex128) int {
+	x, y := real(c), imag(c)
+	y2 := y * y
+	q := (x-0.25)*(x-0.25) + y2
+	if q*(q+(x-0.25)) <= 0.25*y2 {
+		return 1
+	}
+	if (x+1)*(x+1)+y2 <= 1.0/16 {
+		return 2
+	}
+	return 0
+}
+
+// periodicity detects when an orbit returns to a value it had before, using
+// Brent's algorithm: z is compared to a saved value, which is replaced after
+// 1, 2, 4, 8... iterations. This finds cycles of any length with a single
+// comparison per iteration.
+type periodicity struct {
+	saved    complex128
+	eps2     float64
+	i, power int // iterations since saved, and when to save again
+}
+
+// reset starts looking for a cycle which includes z.
+func (p *periodicity) reset(z complex128, eps float64) {
+	p.saved, p.eps2, p.i, p.power = z, eps*eps, 0, 1
+}
+
+// check takes the next z in the orbit, and returns the period of the cycle
+// if z is within epsilon of the saved value, or 0 if it isn't.
+func (p *periodicity) check(z complex128) int {
+	p.i++
+	if abs2(z-p.saved) < p.eps2 {
+		return p.i
+	}
+	if p.i == p.power {
+		p.saved, p.i, p.power = z, 0, p.power*2
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package mandelbrot
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSmoothIterations(t *testing.T) {
+	for _, radius := range []float64{2, 10, 1000} {
+		if got := SmoothIterations(5, radius, radius); math.Abs(got-6) > 1e-9 {
+			t.Errorf("SmoothIterations(5, %v, %v) = %v, want 6", radius, radius, got)
+		}
+		if got := SmoothIterations(5, radius*radius, radius); math.Abs(got-5) > 1e-9 {
+			t.Errorf("SmoothIterations(5, %v, %v) = %v, want 5", radius*radius, radius, got)
+		}
+	}
+}
+
+func TestEscapeJulia(t *testing.T) {
+	// the smooth count should barely change between neighbouring points,
+	// even when their integer counts differ
+	prev := EscapeJulia(0.3, 0.3, 1000, 1000)
+	for x := 1; x <= 100; x++ {
+		c := complex(0.3+float64(x)*1e-3, 0)
+		r := EscapeJulia(c, c, 1000, 1000)
+		if r.In || prev.In {
+			t.Fatalf("EscapeJulia(%v) is in the set", c)
+		}
+		if math.Abs(r.Smooth-prev.Smooth) > 0.25 {
+			t.Errorf("EscapeJulia(%v).Smooth = %v, jumped from %v", c, r.Smooth, prev.Smooth)
+		}
+		prev = r
+	}
+}
+
+func TestDistanceEstimate(t *testing.T) {
+	tests := []struct {
+		c    complex128
+		want float64 // true distance to the set
+	}{
+		{1, 0.75},
+		{-3, 1},
+		{0.5, 0.25},
+		{-2.1, 0.1},
+	}
+	for _, tt := range tests {
+		r := EscapeMandelbrot(tt.c, 1000, 1e6)
+		if r.Distance < tt.want/8 || r.Distance > tt.want {
+			t.Errorf("EscapeMandelbrot(%v).Distance = %v, want about %v", tt.c, r.Distance, tt.want)
+		}
+	}
+}
+
+func TestInBulb(t *testing.T) {
+	tests := []struct {
+		c    complex128
+		want int
+	}{
+		{0, 1},
+		{0.2, 1},
+		{-0.5 + 0.5i, 1},
+		{-1, 2},
+		{-1.2, 2},
+		{0.3, 0},
+		{-0.122 + 0.745i, 0}, // period 3 bulb
+		{-2, 0},
+	}
+	for _, tt := range tests {
+		if got := InBulb(tt.c); got != tt.want {
+			t.Errorf("InBulb(%v) = %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestPeriodicity(t *testing.T) {
+	tests := []struct {
+		c      complex128
+		period int
+	}{
+		{-0.122 + 0.745i, 3},
+		{-1.3, 4},
+		{-1.755, 3},
+		{0.282 + 0.53i, 4},
+	}
+	for _, tt := range tests {
+		r := EscapeMandelbrot(tt.c, 100000, 2)
+		if !r.In || r.Period != tt.period {
+			t.Errorf("EscapeMandelbrot(%v) = in %v, period %d, want period %d", tt.c, r.In, r.Period, tt.period)
+		}
+	}
+
+	// interior detection must not change which points are in the set. the
+	// grid is offset to avoid points like i and -2, which are exactly on the
+	// boundary.
+	plain := Params{}
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 250; x++ {
+			c := complex(-2.0+float64(x)*0.01+0.003, -1.2+float64(y)*0.01+0.003)
+			if got, want := EscapeMandelbrot(c, 2000, 2).In, plain.escape(c, c, 2000, false).In; got != want {
+				t.Errorf("EscapeMandelbrot(%v).In = %v, want %v", c, got, want)
+			}
+		}
+	}
+}
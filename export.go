@@ -0,0 +1,234 @@
+package mandelbrot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Export formats, for analysing a Grid's values with other tools.
+const (
+	// ExportNPY writes each field as a NumPy array of its own type, shaped
+	// (height, width), to base_<field>.npy.
+	ExportNPY = "npy"
+	// ExportRaw writes the fields as little-endian float32 planes, one after
+	// the other, to base.f32, described by the JSON sidecar base.f32.json
+	// (see RawInfo).
+	ExportRaw = "raw"
+	// ExportCSV writes a row for each pixel, with its x and y and then the
+	// fields, to base.csv.
+	ExportCSV = "csv"
+)
+
+// ExportFields are the names of the values exported for each pixel, in the
+// order they are written: the Grid's Iterations, Smooth and Distance, and
+// "in", which is whether the point is in the set.
+var ExportFields = []string{"iterations", "smooth", "in", "distance"}
+
+// RawInfo is the JSON sidecar of an ExportRaw file, which tells how to read
+// it, for example in NumPy with
+//
+//	np.fromfile("base.f32", "<f4").reshape(len(fields), height, width)
+type RawInfo struct {
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	DType  string   `json:"dtype"`  // "<f4"
+	Fields []string `json:"fields"` // ExportFields: the planes in order
+	Config Config   `json:"config"` // that produced the data
+}
+
+// exportField gets the value of a field at index i of g.
+func (g *Grid) exportField(field string, i int) float64 {
+	switch field {
+	case "iterations":
+		return float64(g.Iterations[i])
+	case "smooth":
+		return g.Smooth[i]
+	case "in":
+		if g.Flags[i]&FlagIn != 0 {
+			return 1
+		}
+		return 0
+	default:
+		return float64(g.Distance[i])
+	}
+}
+
+// ExportData reads the data file dataFile with ReadData, and exports its
+// Grid with Export.
+func ExportData(dataFile, base, format string) ([]string, error) {
+	g, cfg, err := ReadData(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	return Export(g, cfg, base, format)
+}
+
+// Export writes the ExportFields of g, and cfg, which produced it, in the
+// given format, one of the Export* constants, to files named from base. It
+// returns the names of the files written. The samples of supersampled pixels
+// aren't exported. The error wraps ErrBadExport for other formats.
+func Export(g *Grid, cfg Config, base, format string) ([]string, error) {
+	switch format {
+	case ExportNPY:
+		var files []string
+		for _, field := range ExportFields {
+			filename := base + "_" + field + ".npy"
+			if err := writeExport(filename, func(w io.Writer) error {
+				return g.encodeNPY(w, field)
+			}); err != nil {
+				return files, err
+			}
+			files = append(files, filename)
+		}
+		return files, nil
+	case ExportRaw:
+		filename := base + ".f32"
+		if err := writeExport(filename, g.encodeRaw); err != nil {
+			return nil, err
+		}
+		info, err := json.MarshalIndent(RawInfo{
+			Width:  g.Width,
+			Height: g.Height,
+			DType:  "<f4",
+			Fields: ExportFields,
+			Config: cfg}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		sidecar := filename + ".json"
+		if err := writeExport(sidecar, func(w io.Writer) error {
+			_, err := w.Write(info)
+			return err
+		}); err != nil {
+			return []string{filename}, err
+		}
+		return []string{filename, sidecar}, nil
+	case ExportCSV:
+		filename := base + ".csv"
+		if err := writeExport(filename, g.encodeCSV); err != nil {
+			return nil, err
+		}
+		return []string{filename}, nil
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrBadExport, format)
+	}
+}
+
+// writeExport creates filename and writes it with encode.
+func writeExport(filename string, encode func(w io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := encode(w); err != nil {
+		return fmt.Errorf("exporting data to %s: %w", filename, err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// encodeNPY writes a field of g as a version 1.0 NPY file: the magic
+// string and version, the length of the header, and the header, a Python
+// dict literal padded with spaces to end with a newline at a multiple of 64
+// bytes, followed by the values in row major order.
+//
+// Format from
+// https://numpy.org/doc/stable/reference/generated/numpy.lib.format.html
+func (g *Grid) encodeNPY(w io.Writer, field string) error {
+	n := g.Width * g.Height
+	var descr string
+	var data interface{}
+	switch field {
+	case "iterations":
+		descr, data = "<u4", g.Iterations[:n]
+	case "smooth":
+		descr, data = "<f8", g.Smooth[:n]
+	case "in":
+		in := make([]uint8, n)
+		for i, f := range g.Flags[:n] {
+			if f&FlagIn != 0 {
+				in[i] = 1
+			}
+		}
+		descr, data = "|b1", in
+	default:
+		descr, data = "<f4", g.Distance[:n]
+	}
+
+	const magic = "\x93NUMPY\x01\x00"
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%d, %d), }",
+		descr, g.Height, g.Width)
+	pad := 63 - (len(magic)+2+len(header))%64
+	header += strings.Repeat(" ", pad) + "\n"
+
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(header))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// encodeRaw writes the ExportFields of g as float32 planes. Iterations over
+// 2^24 lose precision.
+func (g *Grid) encodeRaw(w io.Writer) error {
+	buf := make([]byte, 4*g.Width)
+	for _, field := range ExportFields {
+		for y := 0; y < g.Height; y++ {
+			for x := 0; x < g.Width; x++ {
+				v := float32(g.exportField(field, y*g.Width+x))
+				binary.LittleEndian.PutUint32(buf[4*x:], math.Float32bits(v))
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encodeCSV writes a header row, then the x, y and ExportFields of each
+// point of g, in row major order. Whole numbers are written as integers.
+func (g *Grid) encodeCSV(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "x,y,%s\n", strings.Join(ExportFields, ",")); err != nil {
+		return err
+	}
+	var line []byte
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			i := y*g.Width + x
+			line = strconv.AppendInt(line[:0], int64(x), 10)
+			line = append(line, ',')
+			line = strconv.AppendInt(line, int64(y), 10)
+			line = append(line, ',')
+			line = strconv.AppendUint(line, uint64(g.Iterations[i]), 10)
+			line = append(line, ',')
+			line = strconv.AppendFloat(line, g.Smooth[i], 'g', -1, 64)
+			line = append(line, ',')
+			line = strconv.AppendFloat(line, g.exportField("in", i), 'g', -1, 64)
+			line = append(line, ',')
+			line = strconv.AppendFloat(line, float64(g.Distance[i]), 'g', -1, 32)
+			line = append(line, '\n')
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
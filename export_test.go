@@ -0,0 +1,166 @@
+package mandelbrot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 13, 7
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, "data.dat")
+	if err := WriteData(g, cfg, dataFile); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, "out")
+	n := g.Width * g.Height
+	inside := 0
+	for i := 0; i < n; i++ {
+		if g.Flags[i]&FlagIn != 0 {
+			inside++
+		}
+	}
+	if inside == 0 || inside == n {
+		t.Fatalf("%d of %d points are in the set; want some of each", inside, n)
+	}
+
+	t.Run("npy", func(t *testing.T) {
+		files, err := ExportData(dataFile, base, ExportNPY)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(ExportFields) {
+			t.Fatalf("wrote %v, want a file for each of %v", files, ExportFields)
+		}
+		in := make([]uint8, n)
+		for i := range in {
+			in[i] = g.Flags[i] & FlagIn
+		}
+		want := []struct {
+			descr string
+			data  interface{}
+		}{{"<u4", g.Iterations}, {"<f8", g.Smooth}, {"|b1", in}, {"<f4", g.Distance}}
+		for k, file := range files {
+			if file != base+"_"+ExportFields[k]+".npy" {
+				t.Errorf("file %d = %s", k, file)
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte("\x93NUMPY\x01\x00")) {
+				t.Fatalf("%s: no NPY magic", file)
+			}
+			size := int(binary.LittleEndian.Uint16(data[8:]))
+			header := string(data[10 : 10+size])
+			wantHeader := "{'descr': '" + want[k].descr + "', 'fortran_order': False, 'shape': (7, 13), }"
+			if (10+size)%64 != 0 || header[len(header)-1] != '\n' || header[:len(wantHeader)] != wantHeader {
+				t.Errorf("%s: header %q", file, header)
+			}
+			got := reflect.New(reflect.TypeOf(want[k].data)).Elem()
+			got.Set(reflect.MakeSlice(got.Type(), n, n))
+			if err := binary.Read(bytes.NewReader(data[10+size:]), binary.LittleEndian, got.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Interface(), want[k].data) {
+				t.Errorf("%s: data differs from the grid", file)
+			}
+		}
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		files, err := ExportData(dataFile, base, ExportRaw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, []string{base + ".f32", base + ".f32.json"}) {
+			t.Fatalf("wrote %v", files)
+		}
+		sidecar, err := ioutil.ReadFile(files[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var info RawInfo
+		if err := json.Unmarshal(sidecar, &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Width != 13 || info.Height != 7 || info.DType != "<f4" ||
+			!reflect.DeepEqual(info.Fields, ExportFields) || !reflect.DeepEqual(info.Config, cfg) {
+			t.Errorf("sidecar = %+v", info)
+		}
+
+		values := make([]float32, len(ExportFields)*n)
+		file, err := os.Open(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := binary.Read(file, binary.LittleEndian, values); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			in := float32(0)
+			if g.Flags[i]&FlagIn != 0 {
+				in = 1
+			}
+			want := []float32{float32(g.Iterations[i]), float32(g.Smooth[i]), in, g.Distance[i]}
+			for k := range want {
+				if got := values[k*n+i]; got != want[k] && !(math.IsNaN(float64(got)) && math.IsNaN(float64(want[k]))) {
+					t.Errorf("%s at %d = %g, want %g", ExportFields[k], i, got, want[k])
+				}
+			}
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		files, err := ExportData(dataFile, base, ExportCSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		rows, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != n+1 || !reflect.DeepEqual(rows[0], []string{"x", "y", "iterations", "smooth", "in", "distance"}) {
+			t.Fatalf("%d rows, header %v", len(rows), rows[0])
+		}
+		for i, row := range rows[1:] {
+			x, y := strconv.Itoa(i%g.Width), strconv.Itoa(i/g.Width)
+			iterations := strconv.FormatUint(uint64(g.Iterations[i]), 10)
+			smooth, _ := strconv.ParseFloat(row[3], 64)
+			distance, _ := strconv.ParseFloat(row[5], 32)
+			in := row[4] == "1"
+			if row[0] != x || row[1] != y || row[2] != iterations || in != (g.Flags[i]&FlagIn != 0) ||
+				smooth != g.Smooth[i] || float32(distance) != g.Distance[i] {
+				t.Errorf("row %d = %v", i, row)
+			}
+		}
+	})
+
+	if _, err := Export(g, cfg, base, "xls"); !errors.Is(err, ErrBadExport) {
+		t.Errorf("Export() error = %v, want ErrBadExport", err)
+	}
+	if _, err := ExportData(filepath.Join(dir, "missing.dat"), base, ExportCSV); err == nil {
+		t.Error("ExportData() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,127 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"mandelbrot/big"
+)
+
+// ErrNotBig is wrapped by the error from CompileBig for expressions with
+// operations which can't be done with big.Complex.
+var ErrNotBig = errors.New("expr: not possible with big.Complex")
+
+// BigFunc evaluates a compiled expression with big.Complex, with env holding
+// the values of its variables in the order given to CompileBig. The result is
+// a new big.Complex with the greatest precision of the variables.
+type BigFunc func(env []*big.Complex) *big.Complex
+
+// CompileBig compiles the expression into a BigFunc, with the given
+// variables. Only + - * /, ^ with a constant integer exponent, re, im, conj,
+// norm and abs are available; for other operations the error wraps ErrNotBig.
+// Constants have only the precision of a float64.
+func (e *Expr) CompileBig(vars ...string) (BigFunc, error) {
+	if err := check(e.root, vars); err != nil {
+		return nil, err
+	}
+	return compileBig(fold(e.root), vars)
+}
+
+// compileBig compiles n, which has been checked.
+func compileBig(n *node, vars []string) (BigFunc, error) {
+	switch n.op {
+	case "num":
+		v := n.val
+		return func([]*big.Complex) *big.Complex {
+			return new(big.Complex).SetComplex128(v)
+		}, nil
+	case "var":
+		i := index(vars, n.name)
+		return func(env []*big.Complex) *big.Complex { return new(big.Complex).Copy(env[i]) }, nil
+	}
+
+	args := make([]BigFunc, len(n.args))
+	for i, a := range n.args {
+		f, err := compileBig(a, vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = f
+	}
+	a := args[0]
+
+	switch n.op {
+	case "neg":
+		return func(env []*big.Complex) *big.Complex {
+			x := a(env)
+			return x.Neg(x)
+		}, nil
+	case "re":
+		return func(env []*big.Complex) *big.Complex {
+			x := a(env)
+			x.I.SetInt64(0)
+			return x
+		}, nil
+	case "im":
+		return func(env []*big.Complex) *big.Complex {
+			x := a(env)
+			x.R.Set(&x.I)
+			x.I.SetInt64(0)
+			return x
+		}, nil
+	case "conj":
+		return func(env []*big.Complex) *big.Complex {
+			x := a(env)
+			x.I.Neg(&x.I)
+			return x
+		}, nil
+	case "norm", "abs":
+		abs := n.op == "abs"
+		return func(env []*big.Complex) *big.Complex {
+			x := a(env)
+			x.R.Set(x.AbsSq())
+			if abs {
+				x.R.Sqrt(&x.R)
+			}
+			x.I.SetInt64(0)
+			return x
+		}, nil
+	case "^":
+		k, ok := integer(n.args[1])
+		if !ok || k < 0 {
+			return nil, fmt.Errorf("%w: ^ without a constant, positive integer exponent", ErrNotBig)
+		}
+		return func(env []*big.Complex) *big.Complex {
+			x := a(env)
+			w := new(big.Complex).SetPrec(x.Prec()).SetFloat64(1, 0)
+			for n := k; n > 0; n >>= 1 {
+				if n&1 == 1 {
+					w.Mul(w, x)
+				}
+				x.Mul(x, x)
+			}
+			return w
+		}, nil
+	}
+
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%w: %s", ErrNotBig, n.op)
+	}
+	b := args[1]
+	var op func(z, x, y *big.Complex) *big.Complex
+	switch n.op {
+	case "+":
+		op = (*big.Complex).Add
+	case "-":
+		op = (*big.Complex).Sub
+	case "*":
+		op = (*big.Complex).Mul
+	case "/":
+		op = (*big.Complex).Div
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotBig, n.op)
+	}
+	return func(env []*big.Complex) *big.Complex {
+		// a new result, rather than x, gets the precision of both x and y
+		return op(new(big.Complex), a(env), b(env))
+	}, nil
+}
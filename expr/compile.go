@@ -0,0 +1,167 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Func evaluates a compiled expression, with env holding the values of its
+// variables in the order given to Compile.
+type Func func(env []complex128) complex128
+
+// Compile compiles the expression into a Func, with the given variables.
+// Parts of the expression which don't depend on the variables are evaluated
+// once, here. It is an error for the expression to use other variables.
+func (e *Expr) Compile(vars ...string) (Func, error) {
+	if err := check(e.root, vars); err != nil {
+		return nil, err
+	}
+	return compile(fold(e.root), vars), nil
+}
+
+// check returns an error if n uses a variable which isn't in vars.
+func check(n *node, vars []string) error {
+	if n.op == "var" && index(vars, n.name) < 0 {
+		return fmt.Errorf("expr: unknown variable '%s'", n.name)
+	}
+	for _, a := range n.args {
+		if err := check(a, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func index(vars []string, name string) int {
+	for i, v := range vars {
+		if v == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// fold returns n with its constant parts evaluated.
+func fold(n *node) *node {
+	if n.op == "num" || n.op == "var" {
+		return n
+	}
+	folded := &node{op: n.op, args: make([]*node, len(n.args))}
+	constant := true
+	for i, a := range n.args {
+		folded.args[i] = fold(a)
+		constant = constant && folded.args[i].op == "num"
+	}
+	if constant {
+		return &node{op: "num", val: compile(folded, nil)(nil)}
+	}
+	return folded
+}
+
+// compile compiles n, which has been checked.
+func compile(n *node, vars []string) Func {
+	switch n.op {
+	case "num":
+		v := n.val
+		return func([]complex128) complex128 { return v }
+	case "var":
+		i := index(vars, n.name)
+		return func(env []complex128) complex128 { return env[i] }
+	case "neg":
+		a := compile(n.args[0], vars)
+		return func(env []complex128) complex128 { return -a(env) }
+	}
+
+	if f, ok := funcs[n.op]; ok {
+		a := compile(n.args[0], vars)
+		return func(env []complex128) complex128 { return f(a(env)) }
+	}
+
+	a, b := compile(n.args[0], vars), compile(n.args[1], vars)
+	switch n.op {
+	case "+":
+		return func(env []complex128) complex128 { return a(env) + b(env) }
+	case "-":
+		return func(env []complex128) complex128 { return a(env) - b(env) }
+	case "*":
+		return func(env []complex128) complex128 { return a(env) * b(env) }
+	case "/":
+		return func(env []complex128) complex128 { return a(env) / b(env) }
+	case "^":
+		return compilePow(a, b, n.args[1])
+	case "&&":
+		return func(env []complex128) complex128 { return truth(a(env) != 0 && b(env) != 0) }
+	case "||":
+		return func(env []complex128) complex128 { return truth(a(env) != 0 || b(env) != 0) }
+	}
+
+	// comparisons of the real parts
+	var cmp func(x, y float64) bool
+	switch n.op {
+	case "<":
+		cmp = func(x, y float64) bool { return x < y }
+	case "<=":
+		cmp = func(x, y float64) bool { return x <= y }
+	case ">":
+		cmp = func(x, y float64) bool { return x > y }
+	case ">=":
+		cmp = func(x, y float64) bool { return x >= y }
+	case "==":
+		cmp = func(x, y float64) bool { return x == y }
+	case "!=":
+		cmp = func(x, y float64) bool { return x != y }
+	default:
+		panic("expr: unknown operator " + n.op)
+	}
+	return func(env []complex128) complex128 { return truth(cmp(real(a(env)), real(b(env)))) }
+}
+
+// compilePow compiles base^exp, using multiplication rather than cmplx.Pow
+// when the exponent (whose node is n) is a constant integer.
+func compilePow(base, exp Func, n *node) Func {
+	k, ok := integer(n)
+	if !ok || math.Abs(float64(k)) > 64 {
+		return func(env []complex128) complex128 { return cmplx.Pow(base(env), exp(env)) }
+	}
+
+	switch {
+	case k == 2:
+		return func(env []complex128) complex128 {
+			x := base(env)
+			return x * x
+		}
+	case k >= 0:
+		return func(env []complex128) complex128 { return intPow(base(env), k) }
+	default:
+		return func(env []complex128) complex128 { return 1 / intPow(base(env), -k) }
+	}
+}
+
+// integer returns the value of n if it is a constant integer.
+func integer(n *node) (int, bool) {
+	k := real(n.val)
+	if n.op != "num" || imag(n.val) != 0 || k != math.Trunc(k) || math.Abs(k) > 1<<30 {
+		return 0, false
+	}
+	return int(k), true
+}
+
+// intPow returns z^n, for n >= 0, by repeated squaring.
+func intPow(z complex128, n int) complex128 {
+	w := complex(1, 0)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			w *= z
+		}
+		z *= z
+	}
+	return w
+}
+
+func truth(b bool) complex128 {
+	if b {
+		return 1
+	}
+	return 0
+}
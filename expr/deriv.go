@@ -0,0 +1,185 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotAnalytic is wrapped by the error from Derive for expressions which
+// have no complex derivative.
+var ErrNotAnalytic = errors.New("expr: no complex derivative")
+
+// Derive returns the derivative of the expression with respect to the
+// variable name. Expressions which apply abs, norm, arg, re, im or conj, or
+// comparisons, to something depending on name have no complex derivative,
+// and the error wraps ErrNotAnalytic.
+func (e *Expr) Derive(name string) (*Expr, error) {
+	d, err := derive(e.root, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: d.String(), root: d}, nil
+}
+
+// derive returns the derivative of n with respect to v.
+func derive(n *node, v string) (*node, error) {
+	if !depends(n, v) {
+		return num(0), nil
+	}
+	if n.op == "var" {
+		return num(1), nil
+	}
+
+	da, err := derive(n.args[0], v)
+	if err != nil {
+		return nil, err
+	}
+	a := n.args[0]
+
+	// functions, by the chain rule
+	var outer *node
+	switch n.op {
+	case "neg":
+		return neg(da), nil
+	case "sin":
+		outer = call("cos", a)
+	case "cos":
+		outer = neg(call("sin", a))
+	case "tan":
+		outer = div(num(1), pow(call("cos", a), num(2)))
+	case "sinh":
+		outer = call("cosh", a)
+	case "cosh":
+		outer = call("sinh", a)
+	case "tanh":
+		outer = div(num(1), pow(call("cosh", a), num(2)))
+	case "exp":
+		outer = call("exp", a)
+	case "log":
+		outer = div(num(1), a)
+	case "sqrt":
+		outer = div(num(1), mul(num(2), call("sqrt", a)))
+	}
+	if outer != nil {
+		return mul(outer, da), nil
+	}
+	if len(n.args) < 2 {
+		return nil, fmt.Errorf("%w: %s of %s", ErrNotAnalytic, n.op, v)
+	}
+
+	db, err := derive(n.args[1], v)
+	if err != nil {
+		return nil, err
+	}
+	b := n.args[1]
+	switch n.op {
+	case "+":
+		return add(da, db), nil
+	case "-":
+		return sub(da, db), nil
+	case "*":
+		return add(mul(da, b), mul(a, db)), nil
+	case "/":
+		return div(sub(mul(da, b), mul(a, db)), pow(b, num(2))), nil
+	case "^":
+		if !depends(b, v) {
+			return mul(mul(b, pow(a, sub(b, num(1)))), da), nil
+		}
+		// a^b = exp(b log a)
+		return mul(n, add(mul(db, call("log", a)), div(mul(b, da), a))), nil
+	}
+	return nil, fmt.Errorf("%w: %s of %s", ErrNotAnalytic, n.op, v)
+}
+
+// depends reports if n uses the variable v.
+func depends(n *node, v string) bool {
+	if n.op == "var" {
+		return n.name == v
+	}
+	for _, a := range n.args {
+		if depends(a, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Constructors for nodes, which simplify the results of derive by folding
+// constants and dropping multiplications by 0 and 1 and the like.
+
+func num(v complex128) *node {
+	return &node{op: "num", val: v}
+}
+
+func isNum(n *node, v complex128) bool {
+	return n.op == "num" && n.val == v
+}
+
+func call(f string, a *node) *node {
+	return &node{op: f, args: []*node{a}}
+}
+
+func neg(a *node) *node {
+	if a.op == "num" {
+		return num(-a.val)
+	}
+	return &node{op: "neg", args: []*node{a}}
+}
+
+func add(a, b *node) *node {
+	switch {
+	case a.op == "num" && b.op == "num":
+		return num(a.val + b.val)
+	case isNum(a, 0):
+		return b
+	case isNum(b, 0):
+		return a
+	}
+	return &node{op: "+", args: []*node{a, b}}
+}
+
+func sub(a, b *node) *node {
+	switch {
+	case a.op == "num" && b.op == "num":
+		return num(a.val - b.val)
+	case isNum(b, 0):
+		return a
+	case isNum(a, 0):
+		return neg(b)
+	}
+	return &node{op: "-", args: []*node{a, b}}
+}
+
+func mul(a, b *node) *node {
+	switch {
+	case a.op == "num" && b.op == "num":
+		return num(a.val * b.val)
+	case isNum(a, 0) || isNum(b, 0):
+		return num(0)
+	case isNum(a, 1):
+		return b
+	case isNum(b, 1):
+		return a
+	}
+	return &node{op: "*", args: []*node{a, b}}
+}
+
+func div(a, b *node) *node {
+	switch {
+	case isNum(a, 0):
+		return num(0)
+	case isNum(b, 1):
+		return a
+	}
+	return &node{op: "/", args: []*node{a, b}}
+}
+
+func pow(a, b *node) *node {
+	switch {
+	case isNum(b, 0):
+		return num(1)
+	case isNum(b, 1):
+		return a
+	}
+	return &node{op: "^", args: []*node{a, b}}
+}
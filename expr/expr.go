@@ -0,0 +1,415 @@
+// Package expr parses arithmetic expressions of complex numbers, such as
+// "z^3 - z + c" or "sin(z)*c", and compiles them into fast functions for use
+// as iteration formulas.
+//
+// Expressions have the usual operators + - * / and ^ (power, which is right
+// associative), unary minus, parentheses and |x| for the absolute value.
+// A number directly followed by a name or parenthesis is multiplied by it,
+// as in "2z" or "3i". The names i, pi and e are constants; other names are
+// variables, whose values are given when the expression is evaluated.
+//
+// The functions are sin, cos, tan, sinh, cosh, tanh, exp, log and sqrt, plus
+// abs, norm (|x|^2), arg, re, im and conj, which give real results (as complex
+// numbers with 0 imaginary part) except for conj.
+//
+// For conditions, such as bailouts, the comparisons < <= > >= == != compare
+// the real parts of numbers, and && and || combine them. True is 1 and false
+// is 0, and any number other than 0 is true.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// ErrSyntax is wrapped by the errors for expressions which can't be parsed.
+var ErrSyntax = errors.New("expr: syntax error")
+
+// Expr is a parsed expression.
+type Expr struct {
+	src  string
+	root *node
+}
+
+// node is an operation in the tree of an expression.
+type node struct {
+	op   string     // operator, function name, or "num" or "var" for leaves
+	val  complex128 // of a "num"
+	name string     // of a "var"
+	args []*node
+}
+
+// constants are the names which aren't variables.
+var constants = map[string]complex128{
+	"i":  1i,
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// funcs are the functions of one argument.
+var funcs = map[string]func(complex128) complex128{
+	"sin":  cmplx.Sin,
+	"cos":  cmplx.Cos,
+	"tan":  cmplx.Tan,
+	"sinh": cmplx.Sinh,
+	"cosh": cmplx.Cosh,
+	"tanh": cmplx.Tanh,
+	"exp":  cmplx.Exp,
+	"log":  cmplx.Log,
+	"sqrt": cmplx.Sqrt,
+	"abs":  func(x complex128) complex128 { return complex(cmplx.Abs(x), 0) },
+	"norm": func(x complex128) complex128 { return complex(real(x)*real(x)+imag(x)*imag(x), 0) },
+	"arg":  func(x complex128) complex128 { return complex(cmplx.Phase(x), 0) },
+	"re":   func(x complex128) complex128 { return complex(real(x), 0) },
+	"im":   func(x complex128) complex128 { return complex(imag(x), 0) },
+	"conj": cmplx.Conj,
+}
+
+// Parse parses an expression. The error wraps ErrSyntax.
+func Parse(s string) (*Expr, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected '%s'", t.text)
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// MustParse is Parse, but panics if the expression can't be parsed. It is
+// for expressions in source code, which are known to be good.
+func MustParse(s string) *Expr {
+	e, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// String returns the expression's source.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Vars returns the names of the variables used in the expression, in the
+// order they first appear.
+func (e *Expr) Vars() (names []string) {
+	seen := map[string]bool{}
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.op == "var" && !seen[n.name] {
+			seen[n.name] = true
+			names = append(names, n.name)
+		}
+		for _, a := range n.args {
+			walk(a)
+		}
+	}
+	walk(e.root)
+	return
+}
+
+// Uses reports if the expression uses the variable name.
+func (e *Expr) Uses(name string) bool {
+	for _, v := range e.Vars() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats n, fully parenthesized.
+func (n *node) String() string {
+	switch {
+	case n.op == "num":
+		if imag(n.val) == 0 {
+			return strconv.FormatFloat(real(n.val), 'g', -1, 64)
+		}
+		return fmt.Sprint(n.val)
+	case n.op == "var":
+		return n.name
+	case n.op == "neg":
+		return "-" + n.args[0].String()
+	case funcs[n.op] != nil:
+		return n.op + "(" + n.args[0].String() + ")"
+	}
+	return "(" + n.args[0].String() + " " + n.op + " " + n.args[1].String() + ")"
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNum
+	tokName
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // byte offset in the source
+	num  float64
+}
+
+// operators, longest first so that they are matched greedily
+var operators = []string{"||", "&&", "<=", ">=", "==", "!=", "+", "-", "*", "/", "^", "(", ")", "|", "<", ">"}
+
+// lex splits s into tokens.
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.') {
+				j++
+			}
+			// an exponent, but not the constant e
+			if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+				k := j + 1
+				if k < len(s) && (s[k] == '+' || s[k] == '-') {
+					k++
+				}
+				if k < len(s) && isDigit(s[k]) {
+					for j = k; j < len(s) && isDigit(s[j]); j++ {
+					}
+				}
+			}
+			f, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w at %d: bad number '%s'", ErrSyntax, i+1, s[i:j])
+			}
+			toks = append(toks, token{kind: tokNum, text: s[i:j], pos: i, num: f})
+			i = j
+		case isLetter(c):
+			j := i
+			for j < len(s) && (isLetter(s[j]) || isDigit(s[j])) {
+				j++
+			}
+			toks = append(toks, token{kind: tokName, text: s[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w at %d: unexpected '%c'", ErrSyntax, i+1, c)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, text: "end", pos: len(s)}), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+// parser is a recursive descent parser, with a method for each level of
+// precedence, lowest first.
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators ops.
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.i++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, t.pos+1, fmt.Sprintf(format, args...))
+}
+
+// binary parses operands with operand, separated by any of ops, which are
+// left associative.
+func (p *parser) binary(operand func() (*node, error), ops ...string) (*node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{op: op, args: []*node{left, right}}
+	}
+}
+
+func (p *parser) or() (*node, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *parser) and() (*node, error) {
+	return p.binary(p.compare, "&&")
+}
+
+func (p *parser) compare() (*node, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("<", "<=", ">", ">=", "==", "!="); ok {
+		right, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: op, args: []*node{left, right}}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) sum() (*node, error) {
+	return p.binary(p.term, "+", "-")
+}
+
+func (p *parser) term() (*node, error) {
+	return p.binary(p.unary, "*", "/")
+}
+
+func (p *parser) unary() (*node, error) {
+	if op, ok := p.accept("-", "+"); ok {
+		x, err := p.unary()
+		if err != nil || op == "+" {
+			return x, err
+		}
+		return &node{op: "neg", args: []*node{x}}, nil
+	}
+	return p.power()
+}
+
+func (p *parser) power() (*node, error) {
+	base, err := p.implicit()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("^"); ok {
+		exp, err := p.unary() // right associative, and allows 2^-z
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: "^", args: []*node{base, exp}}, nil
+	}
+	return base, nil
+}
+
+// implicit parses a primary, multiplied by a following name or parenthesis
+// if it is a number, as in 2z.
+func (p *parser) implicit() (*node, error) {
+	first := p.peek()
+	x, err := p.primary()
+	if err != nil || first.kind != tokNum {
+		return x, err
+	}
+	if t := p.peek(); t.kind == tokName || t.kind == tokOp && t.text == "(" {
+		y, err := p.power()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: "*", args: []*node{x, y}}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) primary() (*node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNum:
+		return &node{op: "num", val: complex(t.num, 0)}, nil
+
+	case t.kind == tokName:
+		if c, ok := constants[t.text]; ok {
+			return &node{op: "num", val: c}, nil
+		}
+		if _, ok := funcs[t.text]; ok {
+			if _, ok := p.accept("("); !ok {
+				return nil, p.errorf(p.peek(), "expected '(' after %s", t.text)
+			}
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, p.errorf(p.peek(), "expected ')' to close %s(", t.text)
+			}
+			return &node{op: t.text, args: []*node{x}}, nil
+		}
+		return &node{op: "var", name: t.text}, nil
+
+	case t.kind == tokOp && t.text == "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, p.errorf(p.peek(), "expected ')'")
+		}
+		return x, nil
+
+	case t.kind == tokOp && t.text == "|":
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept("|"); !ok {
+			return nil, p.errorf(p.peek(), "expected '|'")
+		}
+		return &node{op: "abs", args: []*node{x}}, nil
+	}
+
+	if t.kind == tokEOF {
+		return nil, p.errorf(t, "unexpected end")
+	}
+	return nil, p.errorf(t, "unexpected '%s'", t.text)
+}
@@ -0,0 +1,115 @@
+package expr
+
+import (
+	"errors"
+	"mandelbrot/big"
+	"math/cmplx"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	z, c := 0.3-0.7i, -0.4+0.2i
+	tests := []struct {
+		src  string
+		want complex128
+	}{
+		{"z^2 + c", z*z + c},
+		{"z^3 - z + c", z*z*z - z + c},
+		{"sin(z)*c", cmplx.Sin(z) * c},
+		{"2z^2", 2 * z * z},
+		{"-z^2", -(z * z)},
+		{"2^3^2", 512},
+		{"z^-2", 1 / (z * z)},
+		{"z^2.5", cmplx.Pow(z, 2.5)},
+		{"z^c", cmplx.Pow(z, c)},
+		{"3i + 1.5e1", 15 + 3i},
+		{"|z| * 2", complex(2*cmplx.Abs(z), 0)},
+		{"conj(z) + re(c) + im(c)*i", cmplx.Conj(z) + complex(real(c), 0) + complex(0, imag(c))},
+		{"exp(i*pi)", -1 + 1.2246467991473532e-16i},
+		{"norm(z) > 4 || re(z) < 0", 0},
+		{"norm(z) < 4 && im(z) < 0", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := e.Compile("z", "c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f([]complex128{z, c}); cmplx.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{"", "z +", "(z", "sin z", "z $ c", "2..5", "z c", "|z"} {
+		if _, err := Parse(src); !errors.Is(err, ErrSyntax) {
+			t.Errorf("Parse(%q) error = %v, want ErrSyntax", src, err)
+		}
+	}
+	if _, err := MustParse("z + w").Compile("z"); err == nil {
+		t.Error("Compile() with an unknown variable succeeded")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	z, c := 0.3-0.7i, -0.4+0.2i
+	for _, src := range []string{
+		"z^2 + c", "z^3 - z + c", "sin(z)*c", "c*exp(z)/(z - 2)",
+		"z^c", "sqrt(z) + log(z)*tanh(z)", "2^z", "cosh(c)*z^-2"} {
+		e := MustParse(src)
+		f, _ := e.Compile("z", "c")
+		d, err := e.Derive("z")
+		if err != nil {
+			t.Fatalf("Derive(%s) error = %v", src, err)
+		}
+		df, err := d.Compile("z", "c")
+		if err != nil {
+			t.Fatalf("derivative %s error = %v", d, err)
+		}
+
+		h := 1e-6
+		want := (f([]complex128{z + complex(h, 0), c}) - f([]complex128{z - complex(h, 0), c})) / complex(2*h, 0)
+		if got := df([]complex128{z, c}); cmplx.Abs(got-want) > 1e-6*cmplx.Abs(want) {
+			t.Errorf("d/dz %s = %s = %v, want %v", src, d, got, want)
+		}
+	}
+
+	if _, err := MustParse("conj(z)^2 + c").Derive("z"); !errors.Is(err, ErrNotAnalytic) {
+		t.Errorf("Derive() of conj error = %v, want ErrNotAnalytic", err)
+	}
+	if d, err := MustParse("abs(c) + z").Derive("z"); err != nil || d.String() != "1" {
+		t.Errorf("Derive() = %v, %v, want 1", d, err)
+	}
+}
+
+func TestCompileBig(t *testing.T) {
+	z, c := 0.3-0.7i, -0.4+0.2i
+	for _, src := range []string{"z^2 + c", "z^3 - z/c + 2", "conj(z)^2 - c*i", "(|z| + norm(c))*z", "re(z)*im(c) - z^0"} {
+		e := MustParse(src)
+		f, _ := e.Compile("z", "c")
+		bf, err := e.CompileBig("z", "c")
+		if err != nil {
+			t.Fatalf("CompileBig(%s) error = %v", src, err)
+		}
+		env := []*big.Complex{big.NewComplex(real(z), imag(z), 200), big.NewComplex(real(c), imag(c), 200)}
+		got := bf(env)
+		if want := f([]complex128{z, c}); cmplx.Abs(got.Complex128()-want) > 1e-14 {
+			t.Errorf("%s = %v, want %v", src, got.Complex128(), want)
+		}
+		if got.Prec() != 200 {
+			t.Errorf("%s has precision %d, want 200", src, got.Prec())
+		}
+	}
+
+	for _, src := range []string{"sin(z)", "z^c", "z^2.5", "z < c"} {
+		if _, err := MustParse(src).CompileBig("z", "c"); !errors.Is(err, ErrNotBig) {
+			t.Errorf("CompileBig(%s) error = %v, want ErrNotBig", src, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mandelbrot
+
+import (
+	"fmt"
+	"mandelbrot/big"
+	"mandelbrot/expr"
+	"math"
+	stdbig "math/big"
+)
+
+// Expression is an iteration formula z' = f(z, c) typed by the user, such as
+// "z^3 - z + c" or "sin(z)*c". See package expr for what it may contain.
+type Expression struct {
+	Formula string // f(z, c)
+	// Bailout is the condition for z having escaped, which may also use the
+	// iteration number n, such as "re(z)^2 > 100". "" is |z| beyond the
+	// escape radius.
+	Bailout string
+
+	next, bailout expr.Func
+	dz, dc        expr.Func    // partial derivatives of next, nil if it has none
+	bigNext       expr.BigFunc // nil if next can't be done with big.Complex
+}
+
+// NewExpression compiles an Expression. The error is a *ConfigError for the
+// "expression" or "bailout" field.
+func NewExpression(formula, bailout string) (*Expression, error) {
+	bad := func(field, src string, err error) error {
+		return &ConfigError{Field: field, Reason: fmt.Sprintf("'%s' is bad: %v", src, err)}
+	}
+
+	e := &Expression{Formula: formula, Bailout: bailout}
+	f, err := expr.Parse(formula)
+	if err != nil {
+		return nil, bad("expression", formula, err)
+	}
+	if e.next, err = f.Compile("z", "c"); err != nil {
+		return nil, bad("expression", formula, err)
+	}
+	if e.bigNext, err = f.CompileBig("z", "c"); err != nil {
+		e.bigNext = nil
+	}
+
+	// without derivatives there is no distance estimate
+	dz, errz := f.Derive("z")
+	dc, errc := f.Derive("c")
+	if errz == nil && errc == nil {
+		e.dz, _ = dz.Compile("z", "c")
+		e.dc, _ = dc.Compile("z", "c")
+	}
+
+	if bailout != "" {
+		b, err := expr.Parse(bailout)
+		if err != nil {
+			return nil, bad("bailout", bailout, err)
+		}
+		if e.bailout, err = b.Compile("z", "c", "n"); err != nil {
+			return nil, bad("bailout", bailout, err)
+		}
+	}
+	return e, nil
+}
+
+// escaped reports if z has escaped on iteration n, using the Bailout or, if
+// there is none, r2 = radius^2.
+func (e *Expression) escaped(env []complex128, r2 float64) bool {
+	if e.bailout == nil {
+		return abs2(env[0]) > r2
+	}
+	return e.bailout(env) != 0
+}
+
+// escapedExpression is escaped for a formula whose power isn't known. The
+// power is estimated from how much |z|^2 grew in the last iteration, from
+// prev to abs2.
+func escapedExpression(n int, abs2, prev, radius float64, dz complex128) Result {
+	power := 2.0
+	if prev > 1.01 && abs2 > prev {
+		power = math.Log(abs2) / math.Log(prev)
+	}
+	r := escapedPower(n, abs2, radius, power, dz)
+	if math.IsNaN(r.Smooth) || math.IsInf(r.Smooth, 0) {
+		// the bailout didn't leave z big enough to smooth
+		r.Smooth = float64(n) + 1
+	}
+	return r
+}
+
+// escapeExpression is escape for an Expression, tracking the derivative dz
+// in the same way when it has one.
+func (p *Params) escapeExpression(z, c complex128, iterations int, julia bool) Result {
+	e := p.Expression
+	radius := p.radius()
+	r2 := radius * radius
+	env := []complex128{z, c, 0} // z, c, n
+	dz, dc := complex(1, 0), complex(1, 0)
+	if julia {
+		dc = 0
+	}
+	if e.dz == nil {
+		dz = 0
+	}
+	var cycle periodicity
+	cycle.reset(z, p.epsilon())
+	trap := p.Traps.closest(math.Inf(1), z)
+	for i := 0; i < iterations; i++ {
+		if e.dz != nil {
+			dz = e.dz(env)*dz + e.dc(env)*dc
+		}
+		prev := abs2(env[0])
+		env[0], env[2] = e.next(env), complex(float64(i+1), 0)
+		trap = p.Traps.closest(trap, env[0])
+		if e.escaped(env, r2) {
+			// went to infinity
+			return p.Traps.trapped(escapedExpression(i, abs2(env[0]), prev, radius, dz), trap)
+		}
+		if p.Interior {
+			if period := cycle.check(env[0]); period > 0 {
+				// caught in a cycle, so it will never go to infinity
+				return p.Traps.trapped(Result{In: true, Iterations: iterations, Period: period}, trap)
+			}
+		}
+	}
+
+	// did not go to "infinity"
+	return p.Traps.trapped(Result{In: true, Iterations: iterations}, trap)
+}
+
+// nextBig is bigNext, but reports false rather than panicking when the
+// result isn't a number, as when dividing by zero.
+func (e *Expression) nextBig(env []*big.Complex) (z *big.Complex, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, nan := r.(stdbig.ErrNaN); !nan {
+				panic(r)
+			}
+			z, ok = nil, false
+		}
+	}()
+	return e.bigNext(env), true
+}
+
+// runExpression is RunMandelbrot for an Expression, with big.Complex if the
+// Expression can be done with it, or complex128 if not.
+func (j *BigJob) runExpression(iterations int) {
+	e := j.p.Expression
+	if e.bigNext == nil {
+		j.setResult(j.p.Escape(j.N.Complex128(), iterations))
+		return
+	}
+
+	radius := j.p.radius()
+	r2 := radius * radius
+	z, c := new(big.Complex).Copy(j.N), j.N
+	dz, dc := complex(1, 0), complex(1, 0)
+	if j.p.Julia {
+		c, dc = big.NewComplex(real(j.p.C), imag(j.p.C), j.N.Prec()), 0
+	}
+	if e.dz == nil {
+		dz = 0
+	}
+	env, benv := []complex128{z.Complex128(), c.Complex128(), 0}, []*big.Complex{z, c}
+
+	// periodicity checking in full precision, as in RunMandelbrot
+	eps2 := j.p.epsilon() * j.p.epsilon()
+	saved, diff := new(big.Complex).Copy(z), new(big.Complex)
+	since, power := 0, 1
+
+	traps := j.p.Traps
+	trap := traps.closest(math.Inf(1), env[0])
+	for i := 0; i < iterations; i++ {
+		if e.dz != nil {
+			dz = e.dz(env)*dz + e.dc(env)*dc
+		}
+		prev := abs2(env[0])
+		next, ok := e.nextBig(benv)
+		if !ok {
+			// divided by zero, so went to infinity, as with complex128
+			j.setResult(traps.trapped(escapedExpression(i, math.Inf(1), prev, radius, dz), trap))
+			return
+		}
+		z = next
+		benv[0] = z
+		env[0], env[2] = z.Complex128(), complex(float64(i+1), 0)
+		trap = traps.closest(trap, env[0])
+		if e.escaped(env, r2) {
+			j.setResult(traps.trapped(escapedExpression(i, abs2(env[0]), prev, radius, dz), trap))
+			return
+		}
+
+		if j.p.Interior {
+			since++
+			if d, _ := diff.Sub(z, saved).AbsSq().Float64(); d < eps2 {
+				j.setResult(traps.trapped(Result{In: true, Iterations: iterations, Period: since}, trap))
+				return
+			}
+			if since == power {
+				saved.Copy(z)
+				since, power = 0, power*2
+			}
+		}
+	}
+
+	j.setResult(traps.trapped(Result{In: true, Iterations: iterations}, trap))
+}
@@ -0,0 +1,116 @@
+package mandelbrot
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestExpressionEscape(t *testing.T) {
+	e, err := NewExpression("z^2 + c", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Params{Expression: e, EscapeRadius: 100, Interior: true}
+	for _, c := range []complex128{-0.75 + 0.1i, 0.3 + 0.5i, -1.5 + 0.01i, -0.1 + 0.9i, -0.2} {
+		want := EscapeMandelbrot(c, 500, 100)
+		got := p.Escape(c, 500)
+		if got.In != want.In || got.Iterations != want.Iterations {
+			t.Errorf("Escape(%v) = %+v, want %+v", c, got, want)
+		}
+		// the power, and so Smooth, is only estimated
+		if !got.In && math.Abs(got.Smooth-want.Smooth) > 1e-3 {
+			t.Errorf("Escape(%v).Smooth = %g, want %g", c, got.Smooth, want.Smooth)
+		}
+	}
+
+	// a bailout on the real part alone lets z escape later, never sooner
+	e, _ = NewExpression("z^2 + c", "re(z)^2 > 4")
+	p = Params{Expression: e}
+	c := complex(0.1, 1.2)
+	if got, want := p.Escape(c, 500), EscapeMandelbrot(c, 500, 2); got.In || got.Iterations < want.Iterations {
+		t.Errorf("Escape(%v) with bailout = %+v, want escaping after %d", c, got, want.Iterations)
+	}
+
+	// the iteration number is available to the bailout
+	e, _ = NewExpression("z^2 + c", "n >= 7")
+	p = Params{Expression: e}
+	if got := p.Escape(0, 100); got.In || got.Iterations != 6 {
+		t.Errorf("Escape(0) with bailout n >= 7 = %+v, want escaping on iteration 6", got)
+	}
+
+	e, _ = NewExpression("z^3 - z + c", "")
+	p = Params{Expression: e, Julia: true, C: 0.2}
+	if got := p.Escape(0, 500); !got.In {
+		t.Errorf("Julia Escape(0) = %+v, want in", got)
+	}
+	if got := p.Escape(2, 500); got.In {
+		t.Errorf("Julia Escape(2) = %+v, want out", got)
+	}
+}
+
+func TestExpressionBigJob(t *testing.T) {
+	for _, src := range []string{"z^2 + c", "z^3 - z + c", "conj(z)^2 + c", "sin(z)*c"} {
+		e, err := NewExpression(src, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &Params{Expression: e, Interior: true}
+		for _, c := range []complex128{-0.5 + 0.3i, 0.4 + 0.6i, 1.1 - 0.2i} {
+			j := NewBigJob(c, 0, 0, 0)
+			j.p = p
+			j.RunMandelbrot(300)
+			want := p.Escape(c, 300)
+			if got := j.GetResult(); got.In != want.In || got.Iterations != want.Iterations {
+				t.Errorf("%s: BigJob for %v = %+v, want %+v", src, c, got, want)
+			}
+		}
+	}
+}
+
+func TestExpressionBigDivide(t *testing.T) {
+	// z == c at first, and a pixel of the Julia set is at z = 0, so both
+	// divide by zero, which goes to infinity rather than panicking
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 4, 4
+	cfg.Expression = "1/(z-c) + c"
+	var coords Set
+	if err := coords.InitializeBig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	coords.Calculate(cfg.Iterations)
+	for i, j := range coords {
+		if r := j.GetResult(); r.In || r.Iterations != 0 {
+			t.Errorf("%s: job %d = %+v, want escaped at once", cfg.Expression, i, r)
+		}
+	}
+
+	cfg.Expression, cfg.JuliaReal, cfg.JuliaImag = "1/z + c", 0.3, 0.5
+	coords = nil
+	if err := coords.InitializeBig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	coords.Calculate(cfg.Iterations)
+	if _, _, x, y := coords[10].GetImageInfo(); x != 2 || y != 2 {
+		t.Fatalf("job 10 is pixel (%d,%d), want (2,2)", x, y)
+	}
+	if r := coords[10].GetResult(); r.In || r.Iterations != 0 {
+		t.Errorf("%s: pixel at 0 = %+v, want escaped at once", cfg.Expression, r)
+	}
+}
+
+func TestNewExpressionErrors(t *testing.T) {
+	for _, tt := range []struct {
+		formula, bailout, field string
+	}{
+		{"z^2 +", "", "expression"},
+		{"z^2 + w", "", "expression"},
+		{"z^2 + c", "norm(z) >", "bailout"},
+		{"z^2 + c", "k > 4", "bailout"},
+	} {
+		var ce *ConfigError
+		if _, err := NewExpression(tt.formula, tt.bailout); !errors.As(err, &ce) || ce.Field != tt.field {
+			t.Errorf("NewExpression(%q, %q) error = %v, want one for %s", tt.formula, tt.bailout, err, tt.field)
+		}
+	}
+}
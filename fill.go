@@ -0,0 +1,256 @@
+package mandelbrot
+
+import "image"
+
+// Fill algorithms for Config.Fill, which compute the borders of regions of
+// the set and fill their insides rather than computing every pixel.
+const (
+	// FillRectangles divides the plot into rectangles, Mariani–Silver
+	// style: a rectangle whose border is uniform is filled, and any other
+	// is split in two, sharing the border between the halves.
+	FillRectangles = "rectangles"
+	// FillBoundary traces the boundaries between uniform regions, from the
+	// edges of the plot inwards, and fills what they enclose.
+	FillBoundary = "boundary"
+)
+
+// fillStrip is the most rows which are filled together. The plot is divided
+// into strips of rows which are each filled on their own, by a worker, and
+// checkpointed when done.
+const fillStrip = 32
+
+// minRectangle is the width or height at or below which a rectangle is
+// computed rather than split further.
+const minRectangle = 4
+
+// alike reports if pixels i and j are the same for filling: both in the set
+// with the same period and root, and not glitched. Escaped pixels never are,
+// as their smooth iterations and distances vary even where their iterations
+// don't. Since the set has no holes, a region whose border is in it is in it
+// all, though a sparse border can miss fine detail, which FillCheck guards
+// against.
+func (g *Grid) alike(i, j int) bool {
+	return g.Flags[i] == FlagIn && g.Flags[j] == FlagIn &&
+		g.Period[i] == g.Period[j] && g.Root[i] == g.Root[j]
+}
+
+// copyPoint sets point i of the Grid to point j.
+func (g *Grid) copyPoint(i, j int) {
+	g.Iterations[i] = g.Iterations[j]
+	g.Smooth[i] = g.Smooth[j]
+	g.Distance[i] = g.Distance[j]
+	g.Period[i] = g.Period[j]
+	g.Flags[i] = g.Flags[j]
+	g.Root[i] = g.Root[j]
+	g.Trap[i] = g.Trap[j]
+}
+
+// filler fills a strip of the rows of a Grid, computing pixels with pl. It
+// isn't safe for concurrent use, but fillers of different strips are.
+type filler struct {
+	g     *Grid
+	pl    plotter
+	r     image.Rectangle // the strip
+	check int             // Config.FillCheck
+	known []bool          // if each pixel of r has been computed
+}
+
+// fill computes the pixels of r, a strip of rows of the Grid, with pl,
+// filling uniform regions by the algorithm cfg.Fill.
+func (g *Grid) fill(pl plotter, cfg Config, r image.Rectangle) {
+	f := &filler{
+		g:     g,
+		pl:    pl,
+		r:     r,
+		check: cfg.FillCheck,
+		known: make([]bool, r.Dx()*r.Dy())}
+	if cfg.Fill == FillBoundary {
+		f.trace()
+	} else {
+		f.rectangle(r)
+	}
+}
+
+// index returns the index of (x,y) in known.
+func (f *filler) index(x, y int) int {
+	return (y-f.r.Min.Y)*f.r.Dx() + x - f.r.Min.X
+}
+
+// compute computes pixel (x,y) if it hasn't been, and returns its index in
+// the Grid.
+func (f *filler) compute(x, y int) int {
+	if k := f.index(x, y); !f.known[k] {
+		f.g.SetResult(x, y, f.pl.plot(x, y))
+		f.known[k] = true
+	}
+	return y*f.g.Width + x
+}
+
+// checked reports if (x,y) is among the pixels computed with FillCheck to
+// check that filling is right.
+func (f *filler) checked(x, y int) bool {
+	return f.check > 0 && x%f.check == 0 && y%f.check == 0
+}
+
+// rectangle computes the border of r, and then fills its inside if the
+// border is uniform and any checked pixels inside are alike it. Otherwise it
+// splits r in two, or computes its inside if it is small.
+func (f *filler) rectangle(r image.Rectangle) {
+	first := f.compute(r.Min.X, r.Min.Y)
+	uniform := true
+	edge := func(x, y int) {
+		if i := f.compute(x, y); !f.g.alike(first, i) {
+			uniform = false
+		}
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		edge(x, r.Min.Y)
+		edge(x, r.Max.Y-1)
+	}
+	for y := r.Min.Y + 1; y < r.Max.Y-1; y++ {
+		edge(r.Min.X, y)
+		edge(r.Max.X-1, y)
+	}
+	inside := r.Inset(1)
+	if inside.Empty() {
+		return
+	}
+
+	if uniform {
+		for y := inside.Min.Y; y < inside.Max.Y && uniform; y++ {
+			for x := inside.Min.X; x < inside.Max.X; x++ {
+				if f.checked(x, y) {
+					edge(x, y)
+				}
+			}
+		}
+	}
+	switch {
+	case uniform:
+		for y := inside.Min.Y; y < inside.Max.Y; y++ {
+			for x := inside.Min.X; x < inside.Max.X; x++ {
+				if !f.known[f.index(x, y)] {
+					f.g.copyPoint(y*f.g.Width+x, first)
+				}
+			}
+		}
+	case r.Dx() <= minRectangle || r.Dy() <= minRectangle:
+		for y := inside.Min.Y; y < inside.Max.Y; y++ {
+			for x := inside.Min.X; x < inside.Max.X; x++ {
+				f.compute(x, y)
+			}
+		}
+	case r.Dx() >= r.Dy():
+		mid := (r.Min.X + r.Max.X) / 2
+		f.rectangle(image.Rect(r.Min.X, r.Min.Y, mid+1, r.Max.Y))
+		f.rectangle(image.Rect(mid, r.Min.Y, r.Max.X, r.Max.Y))
+	default:
+		mid := (r.Min.Y + r.Max.Y) / 2
+		f.rectangle(image.Rect(r.Min.X, r.Min.Y, r.Max.X, mid+1))
+		f.rectangle(image.Rect(r.Min.X, mid, r.Max.X, r.Max.Y))
+	}
+}
+
+// trace fills the strip by tracing boundaries. Starting with the edges of
+// the strip, each pixel queued is computed along with its neighbours, and
+// those which aren't alike it are queued in turn, so that the queue follows
+// the boundaries between regions. What is never computed is enclosed by a
+// uniform boundary, and is filled from the left. If any checked pixels
+// filled turn out not to be alike what they were filled with, they are
+// queued, and the boundaries traced from them before filling again.
+func (f *filler) trace() {
+	r := f.r
+	queued := make([]bool, len(f.known))
+	filled := make([]bool, len(f.known))
+	var queue []image.Point
+	add := func(x, y int) {
+		if k := f.index(x, y); !queued[k] {
+			queued[k] = true
+			queue = append(queue, image.Pt(x, y))
+		}
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		add(x, r.Min.Y)
+		add(x, r.Max.Y-1)
+	}
+	for y := r.Min.Y + 1; y < r.Max.Y-1; y++ {
+		add(r.Min.X, y)
+		add(r.Max.X-1, y)
+	}
+
+	for len(queue) > 0 {
+		for len(queue) > 0 {
+			p := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			f.scan(p, add)
+		}
+
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x, k := r.Min.X, f.index(r.Min.X, y); x < r.Max.X; x, k = x+1, k+1 {
+				filled[k] = !f.known[k]
+				if filled[k] {
+					i := y*f.g.Width + x
+					f.g.copyPoint(i, i-1)
+				}
+			}
+		}
+
+		if f.check <= 0 {
+			return
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x, k := r.Min.X, f.index(r.Min.X, y); x < r.Max.X; x, k = x+1, k+1 {
+				if !filled[k] || !f.checked(x, y) {
+					continue
+				}
+				i := y*f.g.Width + x
+				period, root := f.g.Period[i], f.g.Root[i]
+				f.compute(x, y)
+				if f.g.Flags[i] != FlagIn || f.g.Period[i] != period || f.g.Root[i] != root {
+					add(x, y)
+				}
+			}
+		}
+	}
+}
+
+// scan computes the neighbours of pixel p, queueing with add those which
+// aren't alike it, and the diagonal neighbours beside them.
+func (f *filler) scan(p image.Point, add func(x, y int)) {
+	x, y := p.X, p.Y
+	i := f.compute(x, y)
+	differs := func(x, y int) bool {
+		return !f.g.alike(i, f.compute(x, y))
+	}
+	r := f.r
+	hasLeft, hasRight := x > r.Min.X, x < r.Max.X-1
+	hasUp, hasDown := y > r.Min.Y, y < r.Max.Y-1
+	left := hasLeft && differs(x-1, y)
+	right := hasRight && differs(x+1, y)
+	up := hasUp && differs(x, y-1)
+	down := hasDown && differs(x, y+1)
+	if left {
+		add(x-1, y)
+	}
+	if right {
+		add(x+1, y)
+	}
+	if up {
+		add(x, y-1)
+	}
+	if down {
+		add(x, y+1)
+	}
+	if hasUp && hasLeft && (up || left) {
+		add(x-1, y-1)
+	}
+	if hasUp && hasRight && (up || right) {
+		add(x+1, y-1)
+	}
+	if hasDown && hasLeft && (down || left) {
+		add(x-1, y+1)
+	}
+	if hasDown && hasRight && (down || right) {
+		add(x+1, y+1)
+	}
+}
@@ -0,0 +1,132 @@
+package mandelbrot
+
+import (
+	"fmt"
+	"image"
+	"sync/atomic"
+	"testing"
+)
+
+// fillConfig is a plot mostly of the set, with regions of several periods.
+func fillConfig() Config {
+	cfg := NewConfig()
+	cfg.CenterReal, cfg.PlotWidth, cfg.PlotHeight = -0.4, 1.6, 1.2
+	cfg.XRes, cfg.YRes = 120, 90
+	return cfg
+}
+
+// countingPlotter counts the pixels it plots.
+type countingPlotter struct {
+	plotter
+	plotted int64
+}
+
+func (pl *countingPlotter) plot(x, y int) Result {
+	atomic.AddInt64(&pl.plotted, 1)
+	return pl.plotter.plot(x, y)
+}
+
+func TestFill(t *testing.T) {
+	for _, interior := range []bool{true, false} {
+		brute := fillConfig()
+		brute.Interior = interior
+		want := NewGrid(brute.XRes, brute.YRes)
+		if err := want.Calculate(brute); err != nil {
+			t.Fatal(err)
+		}
+		n := brute.XRes * brute.YRes
+
+		for _, fill := range []string{FillRectangles, FillBoundary} {
+			for _, check := range []int{0, 5} {
+				cfg := brute
+				cfg.Fill, cfg.FillCheck = fill, check
+				t.Run(fmt.Sprintf("%s interior %v check %d", fill, interior, check), func(t *testing.T) {
+					g := NewGrid(cfg.XRes, cfg.YRes)
+					if err := g.Calculate(cfg); err != nil {
+						t.Fatal(err)
+					}
+					// points in the set are found at different iterations
+					// with Interior, so only their period must match, and
+					// the borders can miss the finest detail
+					differ := 0
+					for i := 0; i < n; i++ {
+						x, y := i%g.Width, i/g.Width
+						if g.Flags[i] != want.Flags[i] || g.Period[i] != want.Period[i] ||
+							g.Flags[i] == 0 && g.At(x, y) != want.At(x, y) {
+							differ++
+						}
+					}
+					if differ > n/1000 {
+						t.Errorf("%d of %d pixels differ from computing them all", differ, n)
+					}
+
+					c128, err := newC128Plotter(cfg)
+					if err != nil {
+						t.Fatal(err)
+					}
+					pl := &countingPlotter{plotter: c128}
+					bounds := image.Rect(0, 0, cfg.XRes, cfg.YRes)
+					for y := 0; y < cfg.YRes; y += fillStrip {
+						g.fill(pl, cfg, image.Rect(0, y, cfg.XRes, y+fillStrip).Intersect(bounds))
+					}
+					if pl.plotted > int64(n)*3/5 {
+						t.Errorf("plotted %d of %d pixels", pl.plotted, n)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestFillCheck(t *testing.T) {
+	// a region whose border is alike, around a pixel which isn't
+	g := NewGrid(9, 9)
+	pl := &fakePlotter{g: NewGrid(9, 9)}
+	for i := range pl.g.Flags {
+		pl.g.Flags[i] = FlagIn
+	}
+	pl.g.Flags[4*9+4] = 0
+	for _, fill := range []string{FillRectangles, FillBoundary} {
+		for _, check := range []int{0, 2} {
+			cfg := Config{Fill: fill, FillCheck: check}
+			g.fill(pl, cfg, image.Rect(0, 0, 9, 9))
+			if found := g.Flags[4*9+4] == 0; found != (check > 0) {
+				t.Errorf("%s checking every %d found the escaped pixel: %v", fill, check, found)
+			}
+		}
+	}
+}
+
+// fakePlotter plots the pixels of a Grid.
+type fakePlotter struct {
+	g *Grid
+}
+
+func (pl *fakePlotter) plot(x, y int) Result {
+	return pl.g.At(x, y)
+}
+
+func (pl *fakePlotter) sample(x, y int, dx, dy float64) Result {
+	return pl.g.At(x, y)
+}
+
+func BenchmarkFill(b *testing.B) {
+	for _, fill := range []struct {
+		name  string
+		fill  string
+		check int
+	}{{"brute", "", 0}, {"rectangles", FillRectangles, 0}, {"boundary", FillBoundary, 0}, {"checked", FillBoundary, 8}} {
+		b.Run(fill.name, func(b *testing.B) {
+			cfg := fillConfig()
+			cfg.XRes, cfg.YRes = 320, 240
+			cfg.Iterations, cfg.Interior = 2000, false
+			cfg.Fill, cfg.FillCheck = fill.fill, fill.check
+			g := NewGrid(cfg.XRes, cfg.YRes)
+			for i := 0; i < b.N; i++ {
+				if err := g.Calculate(cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
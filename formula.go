@@ -0,0 +1,185 @@
+package mandelbrot
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Formula names for Config.Formula. Each is z' = f(z) + c, where f raises
+// z to Config.Power, which is 2 unless set otherwise.
+const (
+	FormulaMandelbrot  = "mandelbrot"   // z^p + c; a Multibrot if p isn't 2
+	FormulaBurningShip = "burning_ship" // (|Re z| + i|Im z|)^p + c
+	FormulaTricorn     = "tricorn"      // conj(z)^p + c, also called the Mandelbar
+	FormulaCeltic      = "celtic"       // |Re z^p| + i Im z^p + c
+	FormulaBuffalo     = "buffalo"      // |Re z^p| + i|Im z^p| + c
+)
+
+// Formula is a recurrence z' = f(z) + c. Its Mandelbrot-like set is made by
+// iterating from z = c for each point c, and its Julia sets by iterating
+// from z = each point with a fixed c.
+type Formula struct {
+	Name  string  // one of the Formula* constants
+	Power float64 // the exponent of z
+
+	// step returns f(z), and its derivative along dz (which, for the formulas
+	// which aren't analytic, depends on the direction of dz).
+	step func(z, dz complex128) (complex128, complex128)
+}
+
+// NewFormula gets the Formula with the given name and power. An empty name is
+// FormulaMandelbrot, and a power of 0 is 2. The error is a *ConfigError for
+// the "formula" or "power" field.
+func NewFormula(name string, power float64) (*Formula, error) {
+	if name == "" {
+		name = FormulaMandelbrot
+	}
+	if power == 0 {
+		power = 2
+	}
+	if !(power > 1) || math.IsInf(power, 0) {
+		return nil, &ConfigError{Field: "power", Reason: "must be greater than 1"}
+	}
+
+	pow, deriv := powers(power)
+	f := &Formula{Name: name, Power: power}
+	switch name {
+	case FormulaMandelbrot:
+		f.step = func(z, dz complex128) (complex128, complex128) {
+			w := pow(z)
+			return w, deriv(z, w) * dz
+		}
+	case FormulaBurningShip:
+		f.step = func(z, dz complex128) (complex128, complex128) {
+			z, dz = absParts(z, dz, true, true)
+			w := pow(z)
+			return w, deriv(z, w) * dz
+		}
+	case FormulaTricorn:
+		f.step = func(z, dz complex128) (complex128, complex128) {
+			z, dz = cmplx.Conj(z), cmplx.Conj(dz)
+			w := pow(z)
+			return w, deriv(z, w) * dz
+		}
+	case FormulaCeltic:
+		f.step = func(z, dz complex128) (complex128, complex128) {
+			w := pow(z)
+			return absParts(w, deriv(z, w)*dz, true, false)
+		}
+	case FormulaBuffalo:
+		f.step = func(z, dz complex128) (complex128, complex128) {
+			w := pow(z)
+			return absParts(w, deriv(z, w)*dz, true, true)
+		}
+	default:
+		return nil, &ConfigError{Field: "formula", Reason: "'" + name + "' is unknown"}
+	}
+	return f, nil
+}
+
+// mandelbrot reports if f is the plain Mandelbrot set formula, z^2 + c, which
+// has faster code (and perturbation) of its own. A nil Formula is too.
+func (f *Formula) mandelbrot() bool {
+	return f == nil || f.Name == FormulaMandelbrot && f.Power == 2
+}
+
+// radius increases the escape radius r, if needed, to one from which orbits
+// are sure to escape for the Formula's power.
+func (f *Formula) radius(r float64) float64 {
+	if f == nil || f.Power >= 2 {
+		return r
+	}
+	return math.Max(r, math.Pow(2, 1/(f.Power-1)))
+}
+
+// powers gets functions which return z^p, and its derivative p*z^(p-1) given
+// w = z^p, choosing the fastest way for p.
+func powers(p float64) (pow func(z complex128) complex128, deriv func(z, w complex128) complex128) {
+	switch n := int(p); {
+	case p == 2:
+		pow = func(z complex128) complex128 { return z * z }
+		deriv = func(z, w complex128) complex128 { return 2 * z }
+		return
+	case float64(n) == p:
+		pow = func(z complex128) complex128 { return intPow(z, n) }
+	default:
+		pow = func(z complex128) complex128 { return realPow(z, p) }
+	}
+	deriv = func(z, w complex128) complex128 {
+		if z == 0 {
+			return 0
+		}
+		return complex(p, 0) * w / z
+	}
+	return
+}
+
+// intPow returns z^n, for n >= 1, by repeated squaring.
+func intPow(z complex128, n int) complex128 {
+	w := complex(1, 0)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			w *= z
+		}
+		z *= z
+	}
+	return w
+}
+
+// realPow returns the principal value of z^p for real p, which is much
+// quicker than cmplx.Pow with a complex exponent.
+func realPow(z complex128, p float64) complex128 {
+	if z == 0 {
+		return 0
+	}
+	r := math.Pow(math.Hypot(real(z), imag(z)), p)
+	sin, cos := math.Sincos(p * math.Atan2(imag(z), real(z)))
+	return complex(r*cos, r*sin)
+}
+
+// absParts takes the absolute value of the real part of z if re is set, and
+// of the imaginary part if im is set, negating the same parts of its
+// derivative dz where they are negated in z.
+func absParts(z, dz complex128, re, im bool) (complex128, complex128) {
+	x, y, dx, dy := real(z), imag(z), real(dz), imag(dz)
+	if re && x < 0 {
+		x, dx = -x, -dx
+	}
+	if im && y < 0 {
+		y, dy = -y, -dy
+	}
+	return complex(x, y), complex(dx, dy)
+}
+
+// escapeFormula is escape for formulas other than the Mandelbrot set's,
+// tracking the derivative dz in the same way.
+func (p *Params) escapeFormula(z, c complex128, iterations int, julia bool) Result {
+	f := p.Formula
+	radius := p.radius()
+	r2 := radius * radius
+	dz, dc := complex(1, 0), complex(1, 0)
+	if julia {
+		dc = 0
+	}
+	var cycle periodicity
+	cycle.reset(z, p.epsilon())
+	trap := p.Traps.closest(math.Inf(1), z)
+	for i := 0; i < iterations; i++ {
+		z, dz = f.step(z, dz)
+		z, dz = z+c, dz+dc
+		trap = p.Traps.closest(trap, z)
+		if a := abs2(z); a > r2 {
+			// went to infinity
+			return p.Traps.trapped(escapedPower(i, a, radius, f.Power, dz), trap)
+		}
+		if p.Interior {
+			if period := cycle.check(z); period > 0 {
+				// caught in a cycle, so it will never go to infinity
+				return p.Traps.trapped(Result{In: true, Iterations: iterations, Period: period}, trap)
+			}
+		}
+	}
+
+	// did not go to "infinity"
+	return p.Traps.trapped(Result{In: true, Iterations: iterations}, trap)
+}
@@ -0,0 +1,120 @@
+package mandelbrot
+
+import (
+	"errors"
+	"math/cmplx"
+	"testing"
+)
+
+func TestPowers(t *testing.T) {
+	zs := []complex128{0.3 + 0.4i, -1.2 + 0.1i, -0.5 - 2i, 3}
+	for _, p := range []float64{2, 3, 4, 7, 2.5, 1.5, 3.9} {
+		pow, deriv := powers(p)
+		for _, z := range zs {
+			want := cmplx.Pow(z, complex(p, 0))
+			got := pow(z)
+			if cmplx.Abs(got-want) > 1e-12*cmplx.Abs(want) {
+				t.Errorf("z^%g for z = %v = %v, want %v", p, z, got, want)
+			}
+			wantDeriv := complex(p, 0) * cmplx.Pow(z, complex(p-1, 0))
+			if d := deriv(z, got); cmplx.Abs(d-wantDeriv) > 1e-12*cmplx.Abs(wantDeriv) {
+				t.Errorf("derivative of z^%g for z = %v = %v, want %v", p, z, d, wantDeriv)
+			}
+		}
+	}
+}
+
+func TestFormulaStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		z, want complex128
+	}{
+		{FormulaMandelbrot, 1 + 2i, -3 + 4i},
+		{FormulaBurningShip, -1 - 1i, 2i},
+		{FormulaTricorn, 1 + 1i, -2i},
+		{FormulaCeltic, 1 + 2i, 3 + 4i},
+		{FormulaBuffalo, 1 - 2i, 3 + 4i},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFormula(tt.name, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := f.step(tt.z, 1); got != tt.want {
+				t.Errorf("f(%v) = %v, want %v", tt.z, got, tt.want)
+			}
+
+			// dz should match the change in z along the real axis
+			c, h := complex(-0.3, 0.2), 1e-7
+			z, dz := c, complex(1, 0)
+			z1 := c + complex(h, 0)
+			for i := 0; i < 5; i++ {
+				z, dz = f.step(z, dz)
+				z, dz = z+c, dz+1
+				z1, _ = f.step(z1, 0)
+				z1 += c + complex(h, 0)
+			}
+			if want := (z1 - z) / complex(h, 0); cmplx.Abs(dz-want) > 1e-4*cmplx.Abs(want) {
+				t.Errorf("dz = %v, want about %v", dz, want)
+			}
+		})
+	}
+}
+
+func TestFormulaEscape(t *testing.T) {
+	tests := []struct {
+		formula string
+		power   float64
+		c       complex128
+		in      bool
+	}{
+		{FormulaMandelbrot, 3, 0.3, true},
+		{FormulaMandelbrot, 3, 0.5, false},
+		{FormulaMandelbrot, 2.5, 0, true},
+		{FormulaMandelbrot, 2.5, 1, false},
+		{FormulaBurningShip, 2, -1.75, true},
+		{FormulaBurningShip, 2, 0.5, false},
+		{FormulaTricorn, 2, -0.2 + 0.1i, true},
+		{FormulaTricorn, 2, 1, false},
+		{FormulaCeltic, 2, -0.5, true},
+		{FormulaBuffalo, 2, 1 + 1i, false},
+	}
+	for _, tt := range tests {
+		f, err := NewFormula(tt.formula, tt.power)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := Params{Formula: f, Interior: true}
+		if got := p.Escape(tt.c, 1000); got.In != tt.in {
+			t.Errorf("%s^%g: Escape(%v).In = %v, want %v", tt.formula, tt.power, tt.c, got.In, tt.in)
+		}
+	}
+
+	// the general loop should agree with the one for the Mandelbrot set
+	f, _ := NewFormula(FormulaMandelbrot, 2)
+	p := Params{Formula: f, EscapeRadius: 100, Interior: true}
+	for _, c := range []complex128{-0.75 + 0.1i, 0.3 + 0.5i, -1.5 + 0.01i, -0.1 + 0.9i} {
+		want := p.escape(c, c, 500, false)
+		if got := p.escapeFormula(c, c, 500, false); got != want {
+			t.Errorf("escapeFormula(%v) = %+v, want %+v", c, got, want)
+		}
+	}
+}
+
+func TestNewFormulaErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		power float64
+		field string
+	}{
+		{"newton", 2, "formula"},
+		{FormulaMandelbrot, 1, "power"},
+		{FormulaTricorn, -2, "power"},
+	} {
+		var ce *ConfigError
+		if _, err := NewFormula(tt.name, tt.power); !errors.As(err, &ce) || ce.Field != tt.field {
+			t.Errorf("NewFormula(%q, %g) error = %v, want one for %s", tt.name, tt.power, err, tt.field)
+		}
+	}
+}
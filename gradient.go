@@ -0,0 +1,553 @@
+package mandelbrot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gradientSize is the length of the ramps made from gradients whose
+// positions are fractions, from GIMP and CSS.
+const gradientSize = 256
+
+// ufSize is the number of positions in an UltraFractal gradient, which wraps
+// around from the last to the first.
+const ufSize = 400
+
+// LoadStops reads the list of `Stop` from a file in the format given by its
+// extension:
+//
+//	.map         Fractint palettes, of a "red green blue" line for each entry
+//	.ggr         GIMP gradients
+//	.ugr, .ufm   the first gradient in an UltraFractal file
+//	.css         the first CSS linear-gradient() in the file
+//
+// and otherwise the JSON of ReadStops. Errors in the files wrap ErrBadRamp.
+func LoadStops(filename string) ([]Stop, error) {
+	var parse func([]byte) ([]Stop, error)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".map":
+		parse = parseMap
+	case ".ggr":
+		parse = parseGGR
+	case ".ugr", ".ufm":
+		parse = parseUGR
+	case ".css":
+		parse = func(data []byte) ([]Stop, error) {
+			s := string(data)
+			i := strings.Index(s, "linear-gradient(")
+			if i < 0 {
+				return nil, fmt.Errorf("%w: no linear-gradient()", ErrBadRamp)
+			}
+			stops, _, err := parseLinearGradient(s[i:])
+			return stops, err
+		}
+	default:
+		return ReadStops(filename)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	stops, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading stops from %s: %w", filename, err)
+	}
+	return stops, nil
+}
+
+// parseMap parses a Fractint palette, with a stop for each of its entries.
+// Anything after the three components on a line is a comment.
+func parseMap(data []byte) ([]Stop, error) {
+	var stops []Stop
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			if i >= len(fields) {
+				return nil, fmt.Errorf("%w: line %d: need red, green and blue", ErrBadRamp, line)
+			}
+			v, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: '%s' isn't a component from 0 to 255", ErrBadRamp, line, fields[i])
+			}
+			rgb[i] = uint8(v)
+		}
+		stops = append(stops, Stop{Position: len(stops), Color: fmt.Sprintf("%02X%02X%02X", rgb[0], rgb[1], rgb[2])})
+	}
+	return stops, scanner.Err()
+}
+
+// ggrSegment is a segment of a GIMP gradient, from left to right, with its
+// halfway color at middle, as fractions of the gradient.
+type ggrSegment struct {
+	left, middle, right float64
+	c0, c1              coords // in sRGB
+	blend, coloring     int
+}
+
+// parseGGR parses a GIMP gradient. Its segments blend in ways which stops
+// can't follow, so it is sampled at gradientSize stops. Alpha is ignored.
+//
+// Format from
+// https://gitlab.gnome.org/GNOME/gimp/-/blob/master/app/core/gimpgradient-load.c
+func parseGGR(data []byte) ([]Stop, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r", ""), "\n")
+	if len(lines) < 3 || strings.TrimSpace(lines[0]) != "GIMP Gradient" {
+		return nil, fmt.Errorf("%w: not a GIMP gradient", ErrBadRamp)
+	}
+	lines = lines[1:]
+	if strings.HasPrefix(lines[0], "Name:") {
+		lines = lines[1:]
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil || n < 1 || n > len(lines)-1 {
+		return nil, fmt.Errorf("%w: bad number of segments '%s'", ErrBadRamp, lines[0])
+	}
+
+	segments := make([]ggrSegment, n)
+	for i := range segments {
+		fields := strings.Fields(lines[i+1])
+		if len(fields) < 13 {
+			return nil, fmt.Errorf("%w: segment %d: need 13 fields, have %d", ErrBadRamp, i, len(fields))
+		}
+		var v [13]float64
+		for j := range v {
+			if v[j], err = strconv.ParseFloat(fields[j], 64); err != nil {
+				return nil, fmt.Errorf("%w: segment %d: '%s' isn't a number", ErrBadRamp, i, fields[j])
+			}
+		}
+		segments[i] = ggrSegment{
+			left: v[0], middle: v[1], right: v[2],
+			c0:    coords{v[3], v[4], v[5]},
+			c1:    coords{v[7], v[8], v[9]},
+			blend: int(v[11]), coloring: int(v[12])}
+	}
+
+	stops := make([]Stop, gradientSize)
+	for i := range stops {
+		x := float64(i) / (gradientSize - 1)
+		// the segment holding x, or the last if the gradient falls short
+		s := &segments[len(segments)-1]
+		for j := range segments {
+			if x <= segments[j].right {
+				s = &segments[j]
+				break
+			}
+		}
+		c := rgbColor(s.at(x))
+		stops[i] = Stop{Position: i, Color: fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B)}
+	}
+	return stops, nil
+}
+
+// at gets the color at x in the segment, as gimp_gradient_get_color_at does.
+func (s *ggrSegment) at(x float64) coords {
+	const eps = 1e-10
+	length := s.right - s.left
+	middle, pos := 0.5, 0.5
+	if length >= eps {
+		middle = (s.middle - s.left) / length
+		pos = math.Max(0, math.Min(1, (x-s.left)/length))
+	}
+
+	// linear, as the halves either side of middle
+	f := 0.0
+	if pos <= middle {
+		if middle >= eps {
+			f = 0.5 * pos / middle
+		}
+	} else if middle > 1-eps {
+		f = 1
+	} else {
+		f = 0.5 + 0.5*(pos-middle)/(1-middle)
+	}
+	switch s.blend {
+	case 1: // curved
+		f = math.Pow(pos, math.Log(0.5)/math.Log(math.Max(middle, eps)))
+	case 2: // sine
+		f = (math.Sin(-math.Pi/2+math.Pi*f) + 1) / 2
+	case 3: // sphere, increasing
+		f = math.Sqrt(1 - (f-1)*(f-1))
+	case 4: // sphere, decreasing
+		f = 1 - math.Sqrt(1-f*f)
+	case 5: // step
+		f = 0
+		if pos >= middle {
+			f = 1
+		}
+	}
+
+	a, b := s.c0, s.c1
+	if s.coloring != 0 {
+		// HSV, anticlockwise or clockwise around the hue
+		a, b = hsv(a), hsv(b)
+		direction := HueIncreasing
+		if s.coloring == 2 {
+			direction = HueDecreasing
+		}
+		b[0] = unwrap(a[0], b[0], direction)
+	}
+	var c coords
+	for k := range c {
+		c[k] = a[k] + f*(b[k]-a[k])
+	}
+	if s.coloring != 0 {
+		c = fromHSV(c)
+	}
+	return c
+}
+
+// parseUGR parses the first gradient in an UltraFractal file, whose entries
+// are like "index=40 color=8421504", with the color's components in the
+// order blue, green, red. The gradient wraps around, so the stops at 0 and
+// at its last position are interpolated from those either side, if they
+// aren't given. A smooth gradient is a spline.
+func parseUGR(data []byte) ([]Stop, error) {
+	s := string(data)
+	start := strings.Index(s, "gradient:")
+	if start < 0 {
+		return nil, fmt.Errorf("%w: no gradient", ErrBadRamp)
+	}
+	s = s[start:]
+	if end := strings.Index(s, "}"); end >= 0 {
+		s = s[:end]
+	}
+	if i := strings.Index(s, "opacity:"); i >= 0 {
+		s = s[:i]
+	}
+
+	type entry struct {
+		index int
+		color coords
+	}
+	var entries []entry
+	easing := ""
+	index := -1
+	for _, field := range strings.Fields(s) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "smooth":
+			if kv[1] == "yes" {
+				easing = EaseSpline
+			}
+		case "index":
+			i, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad index '%s'", ErrBadRamp, kv[1])
+			}
+			index = ((i % ufSize) + ufSize) % ufSize
+		case "color":
+			v, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%w: bad color '%s'", ErrBadRamp, kv[1])
+			}
+			entries = append(entries, entry{index, rgbCoords(bgr(uint32(v)))})
+			index = -1
+		}
+	}
+	if len(entries) < 2 {
+		return nil, fmt.Errorf("%w: need at least 2 colors, have %d", ErrBadRamp, len(entries))
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].index < entries[j].index })
+
+	// where the gradient wraps, between the last entry and the first
+	first, last := entries[0], entries[len(entries)-1]
+	wrap := func(at int) entry {
+		f := float64(at-last.index) / float64(first.index+ufSize-last.index)
+		var c coords
+		for k := range c {
+			c[k] = last.color[k] + f*(first.color[k]-last.color[k])
+		}
+		return entry{at % ufSize, c}
+	}
+	if first.index != 0 {
+		entries = append([]entry{wrap(ufSize)}, entries...)
+	}
+	if last.index != ufSize-1 {
+		entries = append(entries, wrap(ufSize-1))
+	}
+
+	var stops []Stop
+	for _, e := range entries {
+		if len(stops) > 0 && e.index == stops[len(stops)-1].Position {
+			continue
+		}
+		c := rgbColor(e.color)
+		stops = append(stops, Stop{Position: e.index, Color: fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B), Easing: easing})
+	}
+	return stops, nil
+}
+
+// bgr converts an UltraFractal color, whose components are in the order
+// blue, green, red from the lowest byte.
+func bgr(v uint32) color.RGBA {
+	return color.RGBA{uint8(v), uint8(v >> 8), uint8(v >> 16), 255}
+}
+
+// cssSpaces are the color spaces of a CSS interpolation method, such as "in
+// oklab", which have a Space. HSL is interpolated as HSV, which has the same
+// hues.
+var cssSpaces = map[string]string{
+	"srgb":        SpaceSRGB,
+	"srgb-linear": SpaceLinearRGB,
+	"hsl":         SpaceHSV,
+	"lch":         SpaceHCL,
+	"oklab":       SpaceOKLab,
+}
+
+// ParseLinearGradient parses a CSS linear-gradient(), such as
+// "linear-gradient(to right in oklab, red, #00F 40%, rgb(0 255 0))", into
+// stops over gradientSize positions. The direction doesn't matter for a ramp,
+// and positions must be percentages. The error wraps ErrBadRamp or
+// ErrBadColor.
+//
+// Syntax from
+// https://www.w3.org/TR/css-images-4/#linear-gradients
+func ParseLinearGradient(s string) ([]Stop, error) {
+	stops, rest, err := parseLinearGradient(s)
+	if err == nil && strings.Trim(strings.TrimSpace(rest), ";") != "" {
+		return nil, fmt.Errorf("%w: '%s' after linear-gradient()", ErrBadRamp, rest)
+	}
+	return stops, err
+}
+
+// parseLinearGradient parses the CSS linear-gradient() at the start of s, and
+// returns what follows it.
+func parseLinearGradient(s string) (stops []Stop, rest string, err error) {
+	s = strings.TrimSpace(s)
+	const prefix = "linear-gradient("
+	if !strings.HasPrefix(s, prefix) {
+		return nil, "", fmt.Errorf("%w: '%s' isn't a linear-gradient()", ErrBadRamp, s)
+	}
+	args, rest, err := splitArgs(s[len(prefix):])
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the direction and interpolation method, if any
+	space, hue := "", ""
+	if first := strings.Fields(args[0]); len(first) > 0 && isDirection(first) {
+		args = args[1:]
+		for i := 0; i < len(first); i++ {
+			if first[i] != "in" {
+				continue
+			}
+			if i+1 == len(first) || cssSpaces[first[i+1]] == "" {
+				return nil, "", fmt.Errorf("%w: unknown interpolation '%s'", ErrBadRamp, strings.Join(first[i:], " "))
+			}
+			space = cssSpaces[first[i+1]]
+			if i+3 < len(first) && first[i+3] == "hue" {
+				hue = first[i+2]
+			}
+			break
+		}
+	}
+
+	// the colors, with their positions as percentages, or NaN where not given
+	type cssStop struct {
+		color string
+		pos   float64
+	}
+	var css []cssStop
+	for _, arg := range args {
+		c, after, err := cssColor(arg)
+		if err != nil {
+			return nil, "", err
+		}
+		positions := strings.Fields(after)
+		if len(positions) == 0 {
+			css = append(css, cssStop{c, math.NaN()})
+		}
+		if len(positions) > 2 {
+			return nil, "", fmt.Errorf("%w: '%s' has more than 2 positions", ErrBadRamp, arg)
+		}
+		// two positions are the same color at each
+		for _, p := range positions {
+			pct, err := strconv.ParseFloat(strings.TrimSuffix(p, "%"), 64)
+			if err != nil || !strings.HasSuffix(p, "%") {
+				return nil, "", fmt.Errorf("%w: position '%s' isn't a percentage", ErrBadRamp, p)
+			}
+			css = append(css, cssStop{c, pct})
+		}
+	}
+	if len(css) < 2 {
+		return nil, "", fmt.Errorf("%w: need at least 2 colors, have %d", ErrBadRamp, len(css))
+	}
+
+	// positions not given are at the ends, or spread evenly between those
+	// given; those before an earlier position are moved up to it
+	if math.IsNaN(css[0].pos) {
+		css[0].pos = 0
+	}
+	if last := &css[len(css)-1]; math.IsNaN(last.pos) {
+		last.pos = 100
+	}
+	for i := 1; i < len(css); i++ {
+		if !math.IsNaN(css[i].pos) {
+			css[i].pos = math.Max(css[i].pos, css[i-1].pos)
+			continue
+		}
+		j := i
+		for math.IsNaN(css[j].pos) {
+			j++
+		}
+		end := math.Max(css[j].pos, css[i-1].pos)
+		for k := i; k < j; k++ {
+			css[k].pos = css[i-1].pos + (end-css[i-1].pos)*float64(k-i+1)/float64(j-i+1)
+		}
+	}
+
+	// the ends of the ramp are the colors at the ends of the gradient, and a
+	// stop at the same position as another, making a sharp edge, is moved
+	// one along, unless it is at the end, where the edge can't be seen
+	stops = []Stop{{Position: 0, Color: css[0].color}}
+	for _, c := range css {
+		p := round(math.Max(0, math.Min(1, c.pos/100)) * (gradientSize - 1))
+		if last := stops[len(stops)-1].Position; p <= last {
+			if last == gradientSize-1 {
+				break
+			} else if len(stops) > 1 || c.color != stops[0].Color {
+				p = last + 1
+			} else {
+				continue
+			}
+		}
+		stops = append(stops, Stop{Position: p, Color: c.color})
+	}
+	if last := stops[len(stops)-1]; last.Position < gradientSize-1 {
+		stops = append(stops, Stop{Position: gradientSize - 1, Color: last.Color})
+	}
+	for i := range stops {
+		stops[i].Space, stops[i].Hue = space, hue
+	}
+	return stops, rest, nil
+}
+
+// isDirection reports whether the fields of the first argument of a
+// linear-gradient() are a direction or interpolation method rather than a
+// color.
+func isDirection(fields []string) bool {
+	f := fields[0]
+	if f == "to" || f == "in" {
+		return true
+	}
+	for _, unit := range []string{"deg", "grad", "rad", "turn"} {
+		if _, err := strconv.ParseFloat(strings.TrimSuffix(f, unit), 64); err == nil && strings.HasSuffix(f, unit) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArgs splits s, which follows the opening parenthesis of a function,
+// at the commas outside any inner parentheses, up to its closing
+// parenthesis. It returns the arguments and what follows them.
+func splitArgs(s string) (args []string, rest string, err error) {
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return append(args, strings.TrimSpace(s[start:i])), s[i+1:], nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("%w: missing ')'", ErrBadRamp)
+}
+
+// cssNames are the basic CSS color keywords.
+var cssNames = map[string]string{
+	"black": "000000", "silver": "C0C0C0", "gray": "808080", "grey": "808080",
+	"white": "FFFFFF", "maroon": "800000", "red": "FF0000", "purple": "800080",
+	"fuchsia": "FF00FF", "magenta": "FF00FF", "green": "008000", "lime": "00FF00",
+	"olive": "808000", "yellow": "FFFF00", "navy": "000080", "blue": "0000FF",
+	"teal": "008080", "aqua": "00FFFF", "cyan": "00FFFF", "orange": "FFA500",
+}
+
+// cssColor parses the CSS color at the start of s, as "#RGB", "#RRGGBB",
+// with or without alpha, rgb() or one of cssNames, into hex for a Stop, and
+// returns the rest of s. Alpha is ignored.
+func cssColor(s string) (hexColor, rest string, err error) {
+	s = strings.TrimSpace(s)
+	bad := func(c string) (string, string, error) {
+		return "", "", fmt.Errorf("%w '%s'", ErrBadColor, c)
+	}
+
+	if strings.HasPrefix(s, "rgb(") || strings.HasPrefix(s, "rgba(") {
+		open := strings.Index(s, "(")
+		args, rest, err := splitArgs(s[open+1:])
+		if err != nil {
+			return "", "", err
+		}
+		// legacy commas, or the spaces of CSS Color 4 with "/ alpha"
+		components := args
+		if len(args) == 1 {
+			components = strings.Fields(strings.SplitN(args[0], "/", 2)[0])
+		}
+		if len(components) < 3 || len(components) > 4 {
+			return bad(s[:len(s)-len(rest)])
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			c := strings.TrimSpace(components[i])
+			percent := strings.HasSuffix(c, "%")
+			v, err := strconv.ParseFloat(strings.TrimSuffix(c, "%"), 64)
+			if err != nil {
+				return bad(s[:len(s)-len(rest)])
+			}
+			if percent {
+				v = v / 100 * 255
+			}
+			rgb[i] = uint8(round(math.Max(0, math.Min(255, v))))
+		}
+		return fmt.Sprintf("%02X%02X%02X", rgb[0], rgb[1], rgb[2]), rest, nil
+	}
+
+	word := s
+	if i := strings.IndexAny(s, " \t\n"); i >= 0 {
+		word, rest = s[:i], s[i:]
+	}
+	if name, ok := cssNames[strings.ToLower(word)]; ok {
+		return name, rest, nil
+	}
+	if !strings.HasPrefix(word, "#") {
+		return bad(word)
+	}
+	h := word[1:]
+	switch len(h) {
+	case 3, 4:
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	case 6, 8:
+		h = h[:6]
+	default:
+		return bad(word)
+	}
+	if _, err := HexToRGBA(h); err != nil {
+		return "", "", err
+	}
+	return strings.ToUpper(h), rest, nil
+}
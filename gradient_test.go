@@ -0,0 +1,155 @@
+package mandelbrot
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadStops(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		data  string
+		want  []Stop // the first few
+		count int
+	}{
+		{"json", "ramp.json", `[{"color": "000000"}, {"position": 8, "color": "FFFFFF"}]`,
+			[]Stop{{Color: "000000"}, {Position: 8, Color: "FFFFFF"}}, 2},
+		{"fractint", "ramp.MAP", "0 0 0 black\n\n255 128 1\n 10 20 30\n",
+			[]Stop{{Color: "000000"}, {Position: 1, Color: "FF8001"}, {Position: 2, Color: "0A141E"}}, 3},
+		{"gimp", "ramp.ggr", "GIMP Gradient\nName: Test\n2\n" +
+			"0 0.25 0.5 0 0 0 1 1 1 1 1 0 0\n" +
+			"0.5 0.75 1 1 0 0 1 0 0 1 1 5 0 0 0\n",
+			[]Stop{{Color: "000000"}, {Position: 1, Color: "020202"}}, 256},
+		{"ultrafractal", "ramp.ugr", "Test {\ngradient:\n  title=\"Test\" smooth=no\n" +
+			"  index=100 color=255\n  index=300 color=16711680\nopacity:\n  smooth=no index=0 opacity=255\n}\n",
+			[]Stop{{Color: "800080"}, {Position: 100, Color: "FF0000"}, {Position: 300, Color: "0000FF"}, {Position: 399, Color: "7E0081"}}, 4},
+		{"css", "ramp.css", "body { background: linear-gradient(90deg, red, blue); }",
+			[]Stop{{Color: "FF0000"}, {Position: 255, Color: "0000FF"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.file)
+			if err := ioutil.WriteFile(filename, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			stops, err := LoadStops(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stops) != tt.count {
+				t.Fatalf("LoadStops() has %d stops, want %d", len(stops), tt.count)
+			}
+			if got := stops[:len(tt.want)]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadStops() = %v, want %v", got, tt.want)
+			}
+			if _, err := MakeRamp(stops); err != nil {
+				t.Errorf("MakeRamp() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseGGRBlends(t *testing.T) {
+	// white in the middle of each, with the step's edge at its middle
+	data := "GIMP Gradient\n1\n0 0.5 1 0 0 0 1 1 1 1 1 %d 0\n"
+	tests := []struct {
+		blend int
+		at    int // a quarter of the way along
+		want  string
+	}{
+		{0, 64, "404040"},
+		{1, 64, "404040"},
+		{2, 64, "262626"},
+		{3, 64, "A9A9A9"},
+		{4, 64, "080808"},
+		{5, 64, "000000"},
+		{5, 128, "FFFFFF"},
+	}
+	for _, tt := range tests {
+		stops, err := parseGGR([]byte(fmt.Sprintf(data, tt.blend)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stops[tt.at].Color; got != tt.want {
+			t.Errorf("blend %d: stop %d = %s, want %s", tt.blend, tt.at, got, tt.want)
+		}
+	}
+
+	// HSV around the hue from red to blue, anticlockwise through green
+	stops, err := parseGGR([]byte("GIMP Gradient\n1\n0 0.5 1 1 0 0 1 0 0 1 1 0 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stops[128].Color; got != "00FF02" {
+		t.Errorf("hsv: stop 128 = %s, want 00FF02", got)
+	}
+}
+
+func TestParseLinearGradient(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want []Stop
+	}{
+		{"two", "linear-gradient(red, blue)",
+			[]Stop{{Color: "FF0000"}, {Position: 255, Color: "0000FF"}}},
+		{"spread", "linear-gradient(to right, #F00, lime, rgb(0, 0, 255))",
+			[]Stop{{Color: "FF0000"}, {Position: 128, Color: "00FF00"}, {Position: 255, Color: "0000FF"}}},
+		{"positions", "linear-gradient(45deg, black 20%, rgb(100% 50% 0 / 0.5) 40% 60%, #FFFFFF80)",
+			[]Stop{{Color: "000000"}, {Position: 51, Color: "000000"}, {Position: 102, Color: "FF8000"},
+				{Position: 153, Color: "FF8000"}, {Position: 255, Color: "FFFFFF"}}},
+		{"sharp edge", "linear-gradient(white 50%, black 50%)",
+			[]Stop{{Color: "FFFFFF"}, {Position: 128, Color: "FFFFFF"}, {Position: 129, Color: "000000"}, {Position: 255, Color: "000000"}}},
+		{"sharp edge at the end", "linear-gradient(white, black 100%, red 100%, blue)",
+			[]Stop{{Color: "FFFFFF"}, {Position: 255, Color: "000000"}}},
+		{"out of order", "linear-gradient(white, gray 60%, black 30%)",
+			[]Stop{{Color: "FFFFFF"}, {Position: 153, Color: "808080"}, {Position: 154, Color: "000000"}, {Position: 255, Color: "000000"}}},
+		{"interpolation", "linear-gradient(in lch longer hue, red, blue)",
+			[]Stop{{Color: "FF0000", Space: SpaceHCL, Hue: HueLonger}, {Position: 255, Color: "0000FF", Space: SpaceHCL, Hue: HueLonger}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLinearGradient(tt.css)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLinearGradient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGradientErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) ([]Stop, error)
+		data  string
+		want  error
+	}{
+		{"map component", parseMap, "0 0 256\n", ErrBadRamp},
+		{"map short", parseMap, "0 0\n", ErrBadRamp},
+		{"ggr header", parseGGR, "GIMP Palette\n1\n", ErrBadRamp},
+		{"ggr count", parseGGR, "GIMP Gradient\n2\n0 0.5 1 0 0 0 1 1 1 1 1 0 0\n", ErrBadRamp},
+		{"ggr fields", parseGGR, "GIMP Gradient\n1\n0 0.5 1 0 0 0 1\n", ErrBadRamp},
+		{"ugr missing", parseUGR, "Test {\n}\n", ErrBadRamp},
+		{"ugr one color", parseUGR, "Test {\ngradient:\n index=0 color=0\n}\n", ErrBadRamp},
+		{"css function", func(b []byte) ([]Stop, error) { return ParseLinearGradient(string(b)) }, "radial-gradient(red, blue)", ErrBadRamp},
+		{"css unclosed", func(b []byte) ([]Stop, error) { return ParseLinearGradient(string(b)) }, "linear-gradient(red, blue", ErrBadRamp},
+		{"css color", func(b []byte) ([]Stop, error) { return ParseLinearGradient(string(b)) }, "linear-gradient(red, bleu)", ErrBadColor},
+		{"css position", func(b []byte) ([]Stop, error) { return ParseLinearGradient(string(b)) }, "linear-gradient(red 10px, blue)", ErrBadRamp},
+		{"css space", func(b []byte) ([]Stop, error) { return ParseLinearGradient(string(b)) }, "linear-gradient(in xyz, red, blue)", ErrBadRamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.parse([]byte(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
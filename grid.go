@@ -0,0 +1,615 @@
+package mandelbrot
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Flags for Grid.Flags.
+const (
+	FlagIn     uint8 = 1 << iota // the point is in the set
+	FlagGlitch                   // perturbation was unreliable for the point
+)
+
+// Grid holds the results of computing every pixel of a plot, as one flat
+// slice per kind of value, each indexed by y*Width+x. It takes a fraction of
+// the memory of a Set, which allocates a Job for every pixel. The samples of
+// supersampled pixels follow the pixels in each slice.
+type Grid struct {
+	Width, Height int
+	Iterations    []uint32  // number of iterations before becoming unbound
+	Smooth        []float64 // continuous iteration count, see Result
+	Distance      []float32 // estimated distance to the set, see Result
+	Period        []uint32  // period of the orbit, or 0
+	Flags         []uint8   // Flag* bits
+	Root          []uint8   // root reached by Newton's method, from 1, or 0
+	Trap          []float32 // least distance of the orbit to the traps
+
+	// Hits are, for a Buddhabrot, the number of orbits which passed through
+	// each point, for each of Config.Bands. They are nil for other plots.
+	Hits [][]uint32
+
+	// References is the number of reference orbits used by perturbation,
+	// or 0 if it wasn't used.
+	References int
+
+	// Samples are, if the pixels were supersampled, where their samples
+	// are: those of pixel i are from index Samples[i] up to Samples[i+1],
+	// after the Width*Height pixels. Pixels which weren't supersampled
+	// have none. Samples is nil if none were.
+	Samples []uint32
+}
+
+// NewGrid creates an empty Grid of the given size.
+func NewGrid(width, height int) *Grid {
+	n := width * height
+	return &Grid{
+		Width:      width,
+		Height:     height,
+		Iterations: make([]uint32, n),
+		Smooth:     make([]float64, n),
+		Distance:   make([]float32, n),
+		Period:     make([]uint32, n),
+		Flags:      make([]uint8, n),
+		Root:       make([]uint8, n),
+		Trap:       make([]float32, n)}
+}
+
+// makeHits allocates Hits for the given number of bands.
+func (g *Grid) makeHits(bands int) {
+	g.Hits = make([][]uint32, bands)
+	for b := range g.Hits {
+		g.Hits[b] = make([]uint32, g.Width*g.Height)
+	}
+}
+
+// SetResult stores the Result for pixel (x,y).
+func (g *Grid) SetResult(x, y int, r Result) {
+	g.set(y*g.Width+x, r)
+}
+
+// set stores the Result for the pixel or sample at index i.
+func (g *Grid) set(i int, r Result) {
+	g.Iterations[i] = uint32(r.Iterations)
+	g.Smooth[i] = r.Smooth
+	g.Distance[i] = float32(r.Distance)
+	g.Period[i] = uint32(r.Period)
+	var f uint8
+	if r.In {
+		f |= FlagIn
+	}
+	if r.Glitch {
+		f |= FlagGlitch
+	}
+	g.Flags[i] = f
+	g.Root[i] = uint8(r.Root)
+	g.Trap[i] = float32(r.Trap)
+}
+
+// glitched reports if pixel i or any of its samples are glitched.
+func (g *Grid) glitched(i int) bool {
+	if g.Flags[i]&FlagGlitch != 0 {
+		return true
+	}
+	if g.Samples != nil {
+		for _, f := range g.Flags[g.Samples[i]:g.Samples[i+1]] {
+			if f&FlagGlitch != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// At gets the Result for pixel (x,y). Result.Abs is not stored, so is 0.
+func (g *Grid) At(x, y int) Result {
+	i := y*g.Width + x
+	return Result{
+		In:         g.Flags[i]&FlagIn != 0,
+		Iterations: int(g.Iterations[i]),
+		Smooth:     g.Smooth[i],
+		Distance:   float64(g.Distance[i]),
+		Period:     int(g.Period[i]),
+		Glitch:     g.Flags[i]&FlagGlitch != 0,
+		Root:       int(g.Root[i]),
+		Trap:       float64(g.Trap[i])}
+}
+
+// plotter computes the Result for a single pixel of a plot.
+type plotter interface {
+	plot(x, y int) Result
+	// sample computes the point at (dx,dy) pixels from pixel (x,y), where
+	// the pixel has already been plotted.
+	sample(x, y int, dx, dy float64) Result
+}
+
+// c128Plotter computes pixels with complex128.
+type c128Plotter struct {
+	p            *Params
+	left, top    float64
+	xStep, yStep float64
+	iterations   int
+}
+
+func newC128Plotter(cfg Config) (*c128Plotter, error) {
+	p, err := cfg.Params()
+	if err != nil {
+		return nil, err
+	}
+	return &c128Plotter{
+		p:          p,
+		left:       cfg.CenterReal - cfg.PlotWidth/2,
+		top:        cfg.CenterImag + cfg.PlotHeight/2,
+		xStep:      cfg.PlotWidth / float64(cfg.XRes),
+		yStep:      cfg.PlotHeight / float64(cfg.YRes),
+		iterations: cfg.Iterations}, nil
+}
+
+func (pl *c128Plotter) plot(x, y int) Result {
+	return pl.sample(x, y, 0, 0)
+}
+
+func (pl *c128Plotter) sample(x, y int, dx, dy float64) Result {
+	c := complex(pl.left+(float64(x)+dx)*pl.xStep, pl.top-(float64(y)+dy)*pl.yStep)
+	return pl.p.Escape(c, pl.iterations)
+}
+
+// Calculate computes every pixel of the Grid according to cfg, whose XRes and
+// YRes must match the Grid's size. Perturbation is used when
+// cfg.UsePerturbation() says the plot needs it, including fixing any glitches.
+// If cfg.Density is set, the Grid's Hits are computed instead, by sampling
+// cfg.Samples random points. With cfg.Supersample, the samples of the
+// pixels are computed last, after any glitches are fixed. With cfg.Fill,
+// regions in the set are filled rather than computed.
+func (g *Grid) Calculate(cfg Config) error {
+	return g.CalculateContext(context.Background(), cfg, CalcOptions{})
+}
+
+// CalculateProgress is Calculate, but the progress can be obtained by
+// providing the address of a float64 in which [0,1] will be written.
+//
+// Deprecated: reading *progress while it is written is a data race. Use
+// CalculateContext with CalcOptions.Progress.
+func (g *Grid) CalculateProgress(cfg Config, progress *float64) error {
+	var opts CalcOptions
+	if progress != nil {
+		opts.Progress = func(p Progress) { *progress = p.Fraction() }
+	}
+	return g.CalculateContext(context.Background(), cfg, opts)
+}
+
+// CalculateContext is Calculate, reporting progress and checkpointing as set
+// in opts. If ctx is cancelled, it stops soon after with ctx.Err(), leaving
+// the Grid partly computed. cfg is checked with Validate first.
+func (g *Grid) CalculateContext(ctx context.Context, cfg Config, opts CalcOptions) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.XRes != g.Width || cfg.YRes != g.Height {
+		return fmt.Errorf("%dx%d plot doesn't fit a %dx%d grid", cfg.XRes, cfg.YRes, g.Width, g.Height)
+	}
+	if cfg.Density != "" {
+		return g.calculateDensity(ctx, cfg, opts)
+	}
+	g.setSamples(nil)
+	samples := newSampler(cfg).samples()
+
+	var pl plotter
+	var perturb *perturbPlotter
+	if cfg.UsePerturbation() {
+		var err error
+		if perturb, err = newPerturbPlotter(cfg); err != nil {
+			return err
+		}
+		perturb.sizes = make([]float64, len(g.Flags))
+		if samples > 0 {
+			perturb.refs = make([]*Reference, len(g.Flags))
+			perturb.worst = make([]complex128, len(g.Flags))
+		}
+		pl = perturb
+	} else {
+		c128, err := newC128Plotter(cfg)
+		if err != nil {
+			return err
+		}
+		pl = c128
+	}
+
+	var ck *checkpoint
+	if opts.Checkpoint != "" {
+		ck = newCheckpoint(opts.Checkpoint, g, cfg)
+		if opts.Resume {
+			if err := ck.resume(); err != nil {
+				return err
+			}
+		}
+	}
+	// an adaptive plot's samples are counted once it's known which pixels
+	// need them
+	pixels := len(g.Flags)
+	if cfg.Adaptive <= 0 {
+		pixels += len(g.Flags) * samples
+	}
+	m := newMeter(opts, pixels, g.Height)
+	// each worker does a whole row at a time, or when filling, a strip of
+	// rows not yet done
+	height := 1
+	if cfg.Fill != "" {
+		height = fillStrip
+	}
+	var strips []image.Rectangle
+	for y := 0; y < g.Height; y++ {
+		if ck.isDone(y) {
+			m.skip(g.Width, 1)
+			continue
+		}
+		if n := len(strips); n > 0 && strips[n-1].Max.Y == y && strips[n-1].Dy() < height {
+			strips[n-1].Max.Y++
+		} else {
+			strips = append(strips, image.Rect(0, y, g.Width, y+1))
+		}
+	}
+
+	if ck != nil {
+		interval := opts.CheckpointInterval
+		if interval <= 0 {
+			interval = DefaultCheckpointInterval
+		}
+		ck.start(interval)
+	}
+	err := forEach(ctx, len(strips), func(i int) {
+		r := strips[i]
+		if cfg.Fill != "" {
+			g.fill(pl, cfg, r)
+		} else {
+			for x := 0; x < g.Width; x++ {
+				g.SetResult(x, r.Min.Y, pl.plot(x, r.Min.Y))
+			}
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			ck.rowDone(y)
+		}
+		m.add(g.Width*r.Dy(), r.Dy())
+	})
+	if ck != nil {
+		if e := ck.stop(); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// the pixels must be right to know which to supersample, and then the
+	// samples may be glitched too
+	g.References = 0
+	if perturb != nil {
+		if g.References, err = perturb.fixGlitches(ctx, g, 1, cfg.MaxReferences); err != nil {
+			return err
+		}
+	}
+	if err := g.supersample(ctx, cfg, pl, m); err != nil {
+		return err
+	}
+	if perturb != nil && g.Samples != nil {
+		g.References, err = perturb.fixGlitches(ctx, g, g.References, cfg.MaxReferences)
+	}
+	return err
+}
+
+// forEach calls fn(i) for every i in [0,n), concurrently with a worker for
+// each CPU. If ctx is cancelled, no more calls are started, and ctx.Err() is
+// returned once those in progress finish.
+func forEach(ctx context.Context, n int, fn func(i int)) error {
+	// buffered input channel to hold values, 1 for each worker so none have
+	// to block while waiting for jobs
+	workers := runtime.NumCPU()
+	in := make(chan int, workers)
+
+	// start workers
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range in {
+				fn(i)
+			}
+		}()
+	}
+
+	// send jobs to workers
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		select {
+		case in <- i: // will block when buffered channel is full
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	close(in) // close channel to stop workers
+	wg.Wait() // wait for all workers to finish (join)
+	return err
+}
+
+// Grid converts a Set to a Grid of the given size, using the X and Y of each
+// Job. The first Job for each pixel is the pixel, and any others are its
+// samples, as made by Initialize with Config.Supersample.
+func (coords Set) Grid(width, height int) *Grid {
+	g := NewGrid(width, height)
+	count := make([]int, width*height)
+	for _, j := range coords {
+		_, _, x, y := j.GetImageInfo()
+		count[y*width+x]++
+	}
+	for _, n := range count {
+		if n > 1 {
+			g.setSamples(sampleOffsets(len(count), func(i int) int {
+				if count[i] == 0 {
+					return 0
+				}
+				return count[i] - 1
+			}))
+			break
+		}
+	}
+
+	// count the Jobs of each pixel again, as they are stored
+	done := make([]int, len(count))
+	for _, j := range coords {
+		_, _, x, y := j.GetImageInfo()
+		i := y*width + x
+		if k := done[i]; k > 0 {
+			i = int(g.Samples[i]) + k - 1
+		}
+		done[y*width+x]++
+		g.set(i, j.GetResult())
+	}
+	return g
+}
+
+// Picture draws an image.RGBA of the Grid, coloring points outside the set by
+// the ramp entry for their iteration count, and points inside with setColor.
+// The colors change as the iteration counts grow; for colors which don't, use
+// Colorize with ColorHistogram. The ramp mustn't be empty; Colorize returns
+// ErrBadRamp for one that is.
+func (g *Grid) Picture(ramp []color.RGBA, setColor color.RGBA) image.Image {
+	return painter{g: g}.picture(ramp, setColor)
+}
+
+func (p painter) picture(ramp []color.RGBA, setColor color.RGBA) image.Image {
+	g := p.g
+	return p.paint(func(i int) color.RGBA64 {
+		if g.Flags[i]&FlagIn != 0 {
+			return rgba64(setColor)
+		}
+		return rgba64(ramp[int(g.Iterations[i])%len(ramp)])
+	})
+}
+
+// Colorize draws an image like Picture, but colors the points as selected by
+// cfg.Coloring. With cfg.Depth 16, it is an *image.RGBA64, with the ramp
+// interpolated at 16 bits per channel so that smooth colorings don't band.
+// The error wraps ErrBadColor if cfg.SetColor is bad, and ErrBadRamp if the
+// ramp is empty, unless it is a Nebulabrot, which doesn't use it.
+func (g *Grid) Colorize(ramp []color.RGBA, cfg Config) (image.Image, error) {
+	c, err := HexToRGBA(cfg.SetColor)
+	if err != nil {
+		return nil, err
+	}
+	if len(ramp) == 0 && (cfg.Coloring != ColorBuddha || len(g.Hits) <= 1) {
+		return nil, fmt.Errorf("%w: no colors in the ramp", ErrBadRamp)
+	}
+	setColor, p := rgba64(c), g.painter(cfg)
+	in := func(i int) bool { return g.Flags[i]&FlagIn != 0 }
+
+	switch cfg.Coloring {
+	case ColorSmooth:
+		return p.paint(func(i int) color.RGBA64 {
+			if in(i) {
+				return setColor
+			}
+			return p.ramp(ramp, g.Smooth[i])
+		}), nil
+	case ColorPeriod:
+		stride := periodStride * float64(len(ramp))
+		return p.paint(func(i int) color.RGBA64 {
+			switch {
+			case in(i) && g.Period[i] == 0:
+				return setColor
+			case in(i):
+				return rgba64(ramp[int(float64(g.Period[i])*stride)%len(ramp)])
+			}
+			return p.ramp(ramp, g.Smooth[i])
+		}), nil
+	case ColorDistance:
+		// points within a pixel or so of the boundary get the start of the
+		// ramp, making even the thinnest filaments visible
+		pixel := cfg.PlotWidth / float64(g.Width)
+		last := float64(len(ramp) - 1)
+		return p.paint(func(i int) color.RGBA64 {
+			if in(i) {
+				return setColor
+			}
+			f := 1 - math.Exp(-float64(g.Distance[i])/(pixel*distanceFalloff))
+			return rgba64(ramp[round(f*last)])
+		}), nil
+	case ColorHistogram, ColorHistogramSmooth:
+		return p.colorizeHistogram(ramp, setColor, cfg.Coloring == ColorHistogramSmooth), nil
+	case ColorBasin:
+		return g.ColorizeBasins(splitRamp(ramp, g.roots()), cfg)
+	case ColorBuddha:
+		return p.colorizeHits(ramp)
+	case ColorTrap:
+		// all the points, as the orbits of those in the set come closest
+		last := float64(len(ramp) - 1)
+		return p.paint(func(i int) color.RGBA64 {
+			d := float64(g.Trap[i])
+			if math.IsInf(d, 1) {
+				return setColor
+			}
+			return p.ramp(ramp, (1-math.Exp(-d/trapFalloff))*last)
+		}), nil
+	default:
+		return p.picture(ramp, c), nil
+	}
+}
+
+// ColorizeBasins draws an image of a Newton fractal, coloring the points
+// which reached each root with that root's ramp, in turn from ramps, by how
+// quickly they converged. Points which didn't converge are colored with
+// cfg.SetColor, and the error wraps ErrBadColor if it is bad. cfg.Depth is
+// used as by Colorize.
+func (g *Grid) ColorizeBasins(ramps [][]color.RGBA, cfg Config) (image.Image, error) {
+	setColor, err := HexToRGBA(cfg.SetColor)
+	if err != nil {
+		return nil, err
+	}
+	if len(ramps) == 0 {
+		return nil, fmt.Errorf("%w: no ramps for the basins", ErrBadRamp)
+	}
+	p := g.painter(cfg)
+	return p.paint(func(i int) color.RGBA64 {
+		if g.Flags[i]&FlagIn != 0 || g.Root[i] == 0 {
+			return rgba64(setColor)
+		}
+		ramp := ramps[int(g.Root[i]-1)%len(ramps)]
+		f := 1 - math.Exp(-g.Smooth[i]/basinFalloff)
+		return p.ramp(ramp, f*float64(len(ramp)-1))
+	}), nil
+}
+
+// colorizeHits draws an image of a Buddhabrot, with the square root of the
+// hits of each band relative to its most. With one band, that selects the
+// color from the ramp; with more, it is the red, green and blue.
+func (p painter) colorizeHits(ramp []color.RGBA) (image.Image, error) {
+	g := p.g
+	if len(g.Hits) == 0 {
+		return nil, fmt.Errorf("%w: no hits for coloring %s", ErrBadData, ColorBuddha)
+	}
+	scale := make([]float64, len(g.Hits))
+	for b, hits := range g.Hits {
+		most := uint32(1)
+		for _, h := range hits {
+			if h > most {
+				most = h
+			}
+		}
+		scale[b] = 1 / math.Sqrt(float64(most))
+	}
+	level := func(b, i int) float64 {
+		return math.Sqrt(float64(g.Hits[b][i])) * scale[b]
+	}
+
+	if len(g.Hits) == 1 {
+		last := float64(len(ramp) - 1)
+		return p.paint(func(i int) color.RGBA64 {
+			return p.ramp(ramp, level(0, i)*last)
+		}), nil
+	}
+	return p.paint(func(i int) color.RGBA64 {
+		var rgb [maxBands]uint16
+		for b := range g.Hits {
+			rgb[b] = p.channel(level(b, i))
+		}
+		return color.RGBA64{rgb[0], rgb[1], rgb[2], 0xffff}
+	}), nil
+}
+
+// roots returns the number of roots reached by the points of the Grid.
+func (g *Grid) roots() int {
+	n := uint8(0)
+	for _, r := range g.Root {
+		if r > n {
+			n = r
+		}
+	}
+	return int(n)
+}
+
+// splitRamp divides ramp into n parts, for when there is one ramp for the
+// basins of n roots.
+func splitRamp(ramp []color.RGBA, n int) [][]color.RGBA {
+	if n <= 1 || len(ramp) < n {
+		return [][]color.RGBA{ramp}
+	}
+	parts := make([][]color.RGBA, n)
+	for k := range parts {
+		parts[k] = ramp[k*len(ramp)/n : (k+1)*len(ramp)/n]
+	}
+	return parts
+}
+
+// painter draws images of a Grid with 8 bits per channel, or 16 if deep.
+// Its colors are color.RGBA64 either way, which are exact at 8 bits unless
+// deep.
+type painter struct {
+	g    *Grid
+	deep bool
+}
+
+// painter gets the painter for the Depth of cfg.
+func (g *Grid) painter(cfg Config) painter {
+	return painter{g: g, deep: cfg.Depth == 16}
+}
+
+// ramp gets the color at the fractional index v of the ramp, as RampColor64
+// if deep and RampColor if not.
+func (p painter) ramp(ramp []color.RGBA, v float64) color.RGBA64 {
+	if p.deep {
+		return RampColor64(ramp, v)
+	}
+	return rgba64(RampColor(ramp, v))
+}
+
+// channel gets the value of a channel which is the fraction f of full.
+func (p painter) channel(f float64) uint16 {
+	if p.deep {
+		return uint16(round(f * 0xffff))
+	}
+	return uint16(round(f*0xff)) * 0x101
+}
+
+// paint draws an image.RGBA, or an image.RGBA64 if deep, using colorOf to
+// color each point by its index. Supersampled pixels are the average of the
+// colors of their samples.
+func (p painter) paint(colorOf func(i int) color.RGBA64) image.Image {
+	g, rect := p.g, image.Rect(0, 0, p.g.Width, p.g.Height)
+	pixel := colorOf
+	if g.Samples != nil {
+		pixel = func(i int) color.RGBA64 {
+			if lo, hi := int(g.Samples[i]), int(g.Samples[i+1]); hi > lo {
+				return p.average(colorOf, lo, hi)
+			}
+			return colorOf(i)
+		}
+	}
+	if p.deep {
+		img := image.NewRGBA64(rect)
+		forEach(context.Background(), g.Height, func(y int) {
+			for x, i := 0, y*g.Width; x < g.Width; x, i = x+1, i+1 {
+				img.SetRGBA64(x, y, pixel(i))
+			}
+		})
+		return img
+	}
+	img := image.NewRGBA(rect)
+	forEach(context.Background(), g.Height, func(y int) {
+		for x, i := 0, y*g.Width; x < g.Width; x, i = x+1, i+1 {
+			c := pixel(i)
+			img.SetRGBA(x, y, color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+		}
+	})
+	return img
+}
+
+// rgba64 converts c to 16 bits per channel.
+func rgba64(c color.RGBA) color.RGBA64 {
+	return color.RGBA64{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101}
+}
@@ -0,0 +1,84 @@
+package mandelbrot
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGridCalculate(t *testing.T) {
+	shallow := NewConfig()
+	shallow.CenterReal, shallow.CenterImag = -0.75, 0.1
+	shallow.PlotWidth, shallow.PlotHeight = 0.5, 0.5
+	shallow.XRes, shallow.YRes = 40, 30
+	shallow.EscapeRadius = 100
+
+	deep := shallow
+	deep.BigCenterReal = "-1.77810334274064037110522326038852639499207961414628307584575173232969154440"
+	deep.BigCenterImag = "0.00767394242121339392672671947893471774958985018535019684946671264012302378"
+	deep.BigPlotWidth = "1e-30"
+	deep.Iterations = 3000
+	deep.syncBig()
+
+	for name, cfg := range map[string]Config{"shallow": shallow, "deep": deep} {
+		t.Run(name, func(t *testing.T) {
+			coords, err := NewSet(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			coords.Calculate(cfg.Iterations)
+			coords.FixGlitches(cfg.Iterations, cfg.MaxReferences)
+			want := coords.Grid(cfg.XRes, cfg.YRes)
+
+			got := NewGrid(cfg.XRes, cfg.YRes)
+			if err := got.Calculate(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.UsePerturbation() != (got.References > 0) {
+				t.Errorf("References = %d with UsePerturbation() = %v", got.References, cfg.UsePerturbation())
+			}
+
+			for y := 0; y < cfg.YRes; y++ {
+				for x := 0; x < cfg.XRes; x++ {
+					if g, w := got.At(x, y), want.At(x, y); g != w {
+						t.Fatalf("At(%d, %d) = %+v, want %+v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGridCalculateContext(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 64, 48
+
+	var reports []Progress
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	err := g.CalculateContext(context.Background(), cfg, CalcOptions{
+		Progress: func(p Progress) { reports = append(reports, p) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != cfg.YRes {
+		t.Errorf("got %d progress reports, want one per row (%d)", len(reports), cfg.YRes)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Pixels <= reports[i-1].Pixels {
+			t.Fatalf("progress went from %d to %d pixels", reports[i-1].Pixels, reports[i].Pixels)
+		}
+	}
+	if last := reports[len(reports)-1]; last.Pixels != last.TotalPixels || last.Rows != cfg.YRes || last.Fraction() != 1 {
+		t.Errorf("last progress = %+v, want everything done", last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewGrid(cfg.XRes, cfg.YRes).CalculateContext(ctx, cfg, CalcOptions{}); err != context.Canceled {
+		t.Errorf("CalculateContext() with cancelled context = %v, want context.Canceled", err)
+	}
+
+	cfg.XRes++
+	if err := g.CalculateContext(context.Background(), cfg, CalcOptions{}); err == nil {
+		t.Error("CalculateContext() with mismatched size succeeded")
+	}
+}
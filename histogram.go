@@ -0,0 +1,51 @@
+package mandelbrot
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// colorizeHistogram draws an image with the points outside the set spread
+// across the whole ramp by histogram equalization: each point is colored by
+// the fraction of the points outside the set with lower iteration counts.
+// The colors then depend on how the counts are distributed, not on their
+// size, so they stay alike as the iterations grow through a zoom. If smooth
+// is set, the smooth iteration counts are used, interpolating within the
+// histogram's bins.
+func (p painter) colorizeHistogram(ramp []color.RGBA, setColor color.RGBA64, smooth bool) image.Image {
+	g := p.g
+	value := func(i int) float64 {
+		if s := g.Smooth[i]; smooth && s > 0 {
+			return s
+		} else if smooth {
+			return 0
+		}
+		return float64(g.Iterations[i])
+	}
+	in := func(i int) bool { return g.Flags[i]&FlagIn != 0 }
+
+	// the bins of the points outside the set, in order, so that the number
+	// in bins before n is found by searching them; a count for each bin would
+	// grow with the iterations rather than the points
+	var bins []int
+	for i := range g.Flags {
+		if !in(i) {
+			bins = append(bins, int(value(i)))
+		}
+	}
+	sort.Ints(bins)
+	below := func(n int) float64 { return float64(sort.SearchInts(bins, n)) }
+	total := float64(len(bins))
+
+	last := float64(len(ramp) - 1)
+	return p.paint(func(i int) color.RGBA64 {
+		if in(i) {
+			return setColor
+		}
+		v := value(i)
+		n := int(v)
+		f := (below(n) + (v-float64(n))*(below(n+1)-below(n))) / total
+		return p.ramp(ramp, f*last)
+	})
+}
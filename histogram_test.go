@@ -0,0 +1,85 @@
+package mandelbrot
+
+import (
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestColorizeHistogram(t *testing.T) {
+	ramp := make([]color.RGBA, 256)
+	for i := range ramp {
+		ramp[i] = color.RGBA{uint8(i), 0, 0, 255}
+	}
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 50, 40
+	cfg.PlotWidth, cfg.PlotHeight = 3, 2.4
+	cfg.CenterReal = -0.6
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, coloring := range []string{ColorHistogram, ColorHistogramSmooth} {
+		cfg.Coloring = coloring
+		img, err := g.Colorize(ramp, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the colors rise with the iterations, across the whole ramp
+		lowest, highest := uint8(255), uint8(0)
+		for i := range g.Flags {
+			if g.Flags[i]&FlagIn != 0 {
+				continue
+			}
+			r := img.At(i%g.Width, i/g.Width).(color.RGBA).R
+			if r < lowest {
+				lowest = r
+			}
+			if r > highest {
+				highest = r
+			}
+			for j := range g.Flags {
+				if g.Flags[j]&FlagIn == 0 && g.Smooth[j] < g.Smooth[i]-1 {
+					if rj := img.At(j%g.Width, j/g.Width).(color.RGBA).R; rj > r {
+						t.Fatalf("%s: point %d with smooth %g is redder than %d with %g", coloring, j, g.Smooth[j], i, g.Smooth[i])
+					}
+				}
+			}
+		}
+		if lowest > 2 || highest < 250 {
+			t.Errorf("%s: colors span %d to %d, want the whole ramp", coloring, lowest, highest)
+		}
+
+		// the same distribution, later in a zoom, looks the same
+		later := *g
+		later.Iterations = make([]uint32, len(g.Iterations))
+		later.Smooth = make([]float64, len(g.Smooth))
+		for i := range g.Iterations {
+			later.Iterations[i] = g.Iterations[i] + 1000
+			later.Smooth[i] = g.Smooth[i] + 1000
+		}
+		if img2, _ := later.Colorize(ramp, cfg); !reflect.DeepEqual(img2, img) {
+			t.Errorf("%s: adding to the iterations changed the picture", coloring)
+		}
+	}
+}
+
+func TestColorizeHistogramIterations(t *testing.T) {
+	// the colors of points escaping at the most iterations cost no more
+	// than those of the fewest
+	g := NewGrid(3, 1)
+	copy(g.Iterations, []uint32{1, 2, math.MaxUint32})
+	ramp := []color.RGBA{{0, 0, 0, 255}, {255, 0, 0, 255}}
+	img, err := g.Colorize(ramp, Config{Coloring: ColorHistogram, SetColor: "000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []uint8{0, 85, 170} {
+		if r := img.At(x, 0).(color.RGBA).R; r != want {
+			t.Errorf("point %d is %d red, want %d", x, r, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package mandelbrot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image formats, chosen by the extension of the image file.
+const (
+	FormatPNG  = "png"  // lossless, with 16 bits per channel for an image.RGBA64
+	FormatTIFF = "tiff" // lossless and uncompressed, with 16 bits like PNG
+	FormatJPEG = "jpeg" // lossy, with 8 bits per channel
+)
+
+// DefaultQuality is the JPEG quality used when Config.Quality is 0.
+const DefaultQuality = 98
+
+// ImageFormat gets the format of an image file from its extension: ".png",
+// ".tif" or ".tiff", and ".jpg" or ".jpeg", in any case. The error wraps
+// ErrBadFormat for other extensions.
+func ImageFormat(filename string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".png":
+		return FormatPNG, nil
+	case ".tif", ".tiff":
+		return FormatTIFF, nil
+	case ".jpg", ".jpeg":
+		return FormatJPEG, nil
+	default:
+		return "", fmt.Errorf("%w '%s'", ErrBadFormat, ext)
+	}
+}
+
+// WriteImage writes img to filename in the format given by its extension
+// (see ImageFormat), with meta embedded in it if it isn't nil. quality is
+// the JPEG quality from 1 to 100, where 0 is DefaultQuality. PNG and TIFF
+// have 16 bits per channel if img is an *image.RGBA64, as Colorize draws
+// with Config.Depth 16.
+func WriteImage(img image.Image, filename string, quality int, meta *Metadata) error {
+	format, err := ImageFormat(filename)
+	if err != nil {
+		return fmt.Errorf("writing image to %s: %w", filename, err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if err := EncodeImage(w, img, format, quality, meta); err != nil {
+		return fmt.Errorf("writing image to %s: %w", filename, err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// EncodeImage writes img to w in the given format, one of the Format*
+// constants, like WriteImage.
+func EncodeImage(w io.Writer, img image.Image, format string, quality int, meta *Metadata) error {
+	var text []byte
+	if meta != nil {
+		var err error
+		if text, err = json.Marshal(meta); err != nil {
+			return err
+		}
+	}
+
+	// the metadata goes within the images of the standard encoders
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	case FormatTIFF:
+		return encodeTIFF(w, img, text)
+	case FormatJPEG:
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	default:
+		return fmt.Errorf("%w '%s'", ErrBadFormat, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	switch {
+	case text == nil:
+	case format == FormatPNG:
+		data = addPNGMetadata(data, text)
+	case format == FormatJPEG:
+		data = addJPEGMetadata(data, text)
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,179 @@
+package mandelbrot
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// smoothPicture colorizes a small plot smoothly at the given depth.
+func smoothPicture(t *testing.T, depth int) image.Image {
+	t.Helper()
+	ramp, err := MakeRamp([]Stop{{Color: "000000"}, {Position: 8, Color: "FF8000"}, {Position: 16, Color: "FFFFFF"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes, cfg.Iterations = 40, 30, 100
+	cfg.Coloring, cfg.Depth, cfg.ImageFile = ColorSmooth, depth, "out.png"
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	img, err := g.Colorize(ramp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestColorizeDepth(t *testing.T) {
+	shallow, ok := smoothPicture(t, 8).(*image.RGBA)
+	if !ok {
+		t.Fatalf("depth 8 drew a %T, want *image.RGBA", smoothPicture(t, 8))
+	}
+	deep, ok := smoothPicture(t, 16).(*image.RGBA64)
+	if !ok {
+		t.Fatalf("depth 16 drew a %T, want *image.RGBA64", smoothPicture(t, 16))
+	}
+
+	// the same colors, with more bits between the ramp's entries
+	between := false
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			c8, c16 := shallow.RGBAAt(x, y), deep.RGBA64At(x, y)
+			for _, p := range [][2]int{{int(c8.R), int(c16.R)}, {int(c8.G), int(c16.G)}, {int(c8.B), int(c16.B)}} {
+				if d := p[0]*0x101 - p[1]; d > 0x101 || d < -0x101 {
+					t.Fatalf("(%d, %d) is %v at depth 8 but %v at 16", x, y, c8, c16)
+				}
+				if p[1]%0x101 != 0 {
+					between = true
+				}
+			}
+		}
+	}
+	if !between {
+		t.Error("depth 16 has only 8-bit colors")
+	}
+}
+
+func TestColorizeEmptyRamp(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes = 8, 6
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, coloring := range []string{ColorIterations, ColorSmooth, ColorDistance, ColorPeriod, ColorHistogram,
+		ColorHistogramSmooth, ColorBasin, ColorBuddha, ColorTrap} {
+		cfg.Coloring = coloring
+		if _, err := g.Colorize(nil, cfg); !errors.Is(err, ErrBadRamp) {
+			t.Errorf("Colorize() %s with no ramp = %v, want ErrBadRamp", coloring, err)
+		}
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	dir := t.TempDir()
+	for _, depth := range []int{8, 16} {
+		img := smoothPicture(t, depth)
+		for _, ext := range []string{".png", ".tif", ".JPG"} {
+			filename := filepath.Join(dir, "out"+ext)
+			if err := WriteImage(img, filename, 90, nil); err != nil {
+				t.Fatal(err)
+			}
+			var got image.Image
+			switch ext {
+			case ".png", ".JPG":
+				file, err := os.Open(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ext == ".png" {
+					got, err = png.Decode(file)
+				} else {
+					got, err = jpeg.Decode(file)
+				}
+				file.Close()
+				if err != nil {
+					t.Fatalf("%s: %v", ext, err)
+				}
+			case ".tif":
+				got = readTestTIFF(t, filename)
+			}
+
+			if got.Bounds() != img.Bounds() {
+				t.Fatalf("%s at depth %d: bounds %v, want %v", ext, depth, got.Bounds(), img.Bounds())
+			}
+			if ext == ".JPG" {
+				continue // lossy
+			}
+			if _, deep := got.(*image.RGBA64); deep != (depth == 16) {
+				t.Errorf("%s at depth %d decoded as %T", ext, depth, got)
+			}
+			for y := 0; y < 30; y++ {
+				for x := 0; x < 40; x++ {
+					if a, b := color.RGBA64Model.Convert(img.At(x, y)), color.RGBA64Model.Convert(got.At(x, y)); a != b {
+						t.Fatalf("%s at depth %d: (%d, %d) is %v, want %v", ext, depth, x, y, b, a)
+					}
+				}
+			}
+		}
+	}
+
+	if err := WriteImage(smoothPicture(t, 8), filepath.Join(dir, "out.gif"), 0, nil); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("WriteImage(.gif) error = %v, want ErrBadFormat", err)
+	}
+}
+
+// readTestTIFF decodes the TIFFs written by encodeTIFF.
+func readTestTIFF(t *testing.T, filename string) image.Image {
+	t.Helper()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "II*\x00" {
+		t.Fatalf("TIFF header is %q", data[:4])
+	}
+	le := binary.LittleEndian
+	dir := data[le.Uint32(data[4:]):]
+	tags := map[uint16]uint32{}
+	for i, n := 0, int(le.Uint16(dir)); i < n; i++ {
+		e := dir[2+12*i:]
+		v := le.Uint32(e[8:])
+		if le.Uint16(e[2:]) == tiffShort {
+			v = uint32(le.Uint16(e[8:]))
+		}
+		tags[le.Uint16(e)] = v
+	}
+	width, height, offset := int(tags[tiffImageWidth]), int(tags[tiffImageLength]), tags[tiffStripOffsets]
+	if tags[tiffCompression] != 1 || tags[tiffPhotometric] != 2 || tags[tiffSamplesPerPixel] != 3 {
+		t.Fatalf("TIFF tags are %v", tags)
+	}
+	// BitsPerSample has 3 values, so it holds their offset
+	bits := le.Uint16(data[tags[tiffBitsPerSample]:])
+
+	pixels := data[offset:]
+	if bits == 16 {
+		img := image.NewRGBA64(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			p := pixels[6*i:]
+			img.SetRGBA64(i%width, i/width, color.RGBA64{le.Uint16(p), le.Uint16(p[2:]), le.Uint16(p[4:]), 0xffff})
+		}
+		return img
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		p := pixels[3*i:]
+		img.SetRGBA(i%width, i/width, color.RGBA{p[0], p[1], p[2], 255})
+	}
+	return img
+}
@@ -1,17 +1,16 @@
 package mandelbrot
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"mandelbrot/big"
-	"mandelbrot/gob"
+	"math"
 	stdbig "math/big"
 	"math/cmplx"
 	"os"
-	"runtime"
-	"sync"
 )
 
 const precision = 1024
@@ -31,6 +30,7 @@ type Job interface {
 	// PerformAction(Action)
 	RunMandelbrot(int)
 	GetImageInfo() (bool, int, int, int)
+	GetResult() Result
 }
 
 // Job contains information about a specific point in the mandelbrot set.
@@ -38,8 +38,16 @@ type C128Job struct {
 	N          complex128 // the complex number in question
 	In         bool       // rough classification
 	Iterations int        // number of iterations before becoming unbound
+	Abs        float64    // |z| when the point escaped
+	Smooth     float64    // continuous iteration count
+	Distance   float64    // estimated distance to the set
+	Period     int        // period of the orbit, if found to be periodic
+	Root       int        // root reached by Newton's method, from 1, or 0
+	Trap       float64    // least distance of the orbit to the traps
 	Index      int        // for indexing/sorting in slice
 	X, Y       int        // for making jpgs
+
+	p *Params
 }
 
 // func (j C128Job) SetN(c complex128) {
@@ -61,19 +69,35 @@ func NewC128Job(n complex128, index, x, y int) *C128Job {
 // }
 
 func (j *C128Job) RunMandelbrot(iterations int) {
-	j.In, j.Iterations = IsMemberMandelbrot(j.N, iterations)
+	j.setResult(j.p.Escape(j.N, iterations))
+}
+
+func (j *C128Job) setResult(r Result) {
+	j.In, j.Iterations, j.Abs, j.Smooth, j.Distance, j.Period, j.Root, j.Trap = r.In, r.Iterations, r.Abs, r.Smooth, r.Distance, r.Period, r.Root, r.Trap
 }
 
 func (j *C128Job) GetImageInfo() (bool, int, int, int) {
 	return j.In, j.Iterations, j.X, j.Y
 }
 
+func (j *C128Job) GetResult() Result {
+	return Result{In: j.In, Iterations: j.Iterations, Abs: j.Abs, Smooth: j.Smooth, Distance: j.Distance, Period: j.Period, Root: j.Root, Trap: j.Trap}
+}
+
 type BigJob struct {
 	N          *big.Complex
 	In         bool
 	Iterations int
+	Abs        float64
+	Smooth     float64
+	Distance   float64
+	Period     int
+	Root       int
+	Trap       float64
 	Index      int
 	X, Y       int
+
+	p *Params
 }
 
 // func (j BigJob) SetN(c complex128) {
@@ -99,25 +123,64 @@ func NewBigJob(n complex128, index, x, y int) *BigJob {
 // Math from
 // https://randomascii.wordpress.com/2011/08/13/faster-fractals-through-algebra/
 func (j *BigJob) RunMandelbrot(iterations int) {
+	if j.p != nil && j.p.Newton != nil {
+		// converging needs no more precision than complex128
+		j.setResult(j.p.Escape(j.N.Complex128(), iterations))
+		return
+	}
+	if j.p != nil && j.p.Expression != nil {
+		j.runExpression(iterations)
+		return
+	}
+	radius := j.p.radius()
 	z := new(big.Complex).Copy(j.N)
+	dz := complex(1, 0) // dz/dc for the distance estimate; precision isn't needed
+
+	// periodicity checking as in periodicity, but with the full precision
+	// of z, since at deep zoom nearby orbits differ by less than a float64
+	// can resolve
+	interior, eps2 := j.p != nil && j.p.Interior, j.p.epsilon()*j.p.epsilon()
+	saved, diff := new(big.Complex).Copy(z), new(big.Complex)
+	since, power := 0, 1
+
+	var traps Traps
+	if j.p != nil {
+		traps = j.p.Traps
+	}
+	trap := traps.closest(math.Inf(1), z.Complex128())
 	for i := 0; i < iterations; i++ {
 		realsq := new(stdbig.Float).Mul(&z.R, &z.R)
 		imagsq := new(stdbig.Float).Mul(&z.I, &z.I)
 		rsq, _ := realsq.Float64()
 		isq, _ := imagsq.Float64()
-		if rsq+isq > 4.0 {
-			j.In = false
-			j.Iterations = i
+		if rsq+isq > radius*radius {
+			j.setResult(traps.trapped(escaped(i, rsq+isq, radius, dz), trap))
 			return
 		}
+		dz = 2*z.Complex128()*dz + 1
 		z.I.Mul(&z.R, &z.I)
 		z.I.Add(&z.I, &z.I)
 		z.I.Add(&z.I, &j.N.I)
 		z.R.Add(new(stdbig.Float).Sub(realsq, imagsq), &j.N.R)
+		if traps != nil {
+			// (converting z only when it's needed)
+			trap = traps.closest(trap, z.Complex128())
+		}
+
+		if interior {
+			since++
+			if d, _ := diff.Sub(z, saved).AbsSq().Float64(); d < eps2 {
+				j.setResult(traps.trapped(Result{In: true, Iterations: iterations, Period: since}, trap))
+				return
+			}
+			if since == power {
+				saved.Copy(z)
+				since, power = 0, power*2
+			}
+		}
 	}
 
-	j.In = true
-	j.Iterations = iterations
+	j.setResult(traps.trapped(Result{In: true, Iterations: iterations}, trap))
 }
 
 func (j *BigJob) RunMandelbrotV1(iterations int) {
@@ -144,16 +207,34 @@ func (j *BigJob) GetImageInfo() (bool, int, int, int) {
 	return j.In, j.Iterations, j.X, j.Y
 }
 
+func (j *BigJob) GetResult() Result {
+	return Result{In: j.In, Iterations: j.Iterations, Abs: j.Abs, Smooth: j.Smooth, Distance: j.Distance, Period: j.Period, Root: j.Root, Trap: j.Trap}
+}
+
+func (j *BigJob) setResult(r Result) {
+	j.In, j.Iterations, j.Abs, j.Smooth, j.Distance, j.Period, j.Root, j.Trap = r.In, r.Iterations, r.Abs, r.Smooth, r.Distance, r.Period, r.Root, r.Trap
+}
+
 // Initialize sets up a MandelSet according to the configuration specified.
-func (coords *Set) Initialize(cfg Config) {
+// With cfg.Supersample, the Job for each pixel is followed by Jobs for its
+// samples, for every pixel, as cfg.Adaptive needs the pixels done first.
+// The error is that of cfg.Params.
+func (coords *Set) Initialize(cfg Config) error {
 	left, right := cfg.CenterReal-(cfg.PlotWidth/2), cfg.CenterReal+(cfg.PlotWidth/2)
 	top, bottom := cfg.CenterImag+(cfg.PlotHeight/2), cfg.CenterImag-(cfg.PlotHeight/2)
 	yStep := (top - bottom) / float64(cfg.YRes)
 	xStep := (right - left) / float64(cfg.XRes)
+	p, err := cfg.Params()
+	if err != nil {
+		return err
+	}
+	s := newSampler(cfg)
 
 	// Initialize coords
-	for i, h, y := 0, 0, top; h < cfg.YRes; h, y = h+1, y-yStep {
-		for w, x := 0, left; w < cfg.XRes; w, x = w+1, x+xStep {
+	for i, h := 0, 0; h < cfg.YRes; h++ {
+		y := top - float64(h)*yStep
+		for w := 0; w < cfg.XRes; w++ {
+			x := left + float64(w)*xStep
 			// var j Job
 			// if useBig {
 			// 	j = NewBigJob(complex(x, y), i, w, h)
@@ -161,28 +242,71 @@ func (coords *Set) Initialize(cfg Config) {
 			// 	j = NewC128Job(complex(x, y), i, w, h)
 			// }
 
-			*coords = append(*coords, NewC128Job(complex(x, y), i, w, h))
+			j := NewC128Job(complex(x, y), i, w, h)
+			j.p = p
+			*coords = append(*coords, j)
 			i++
+
+			for k := 0; k < s.samples(); k++ {
+				dx, dy := s.offset(h*cfg.XRes+w, k)
+				j := NewC128Job(complex(left+(float64(w)+dx)*xStep, top-(float64(h)+dy)*yStep), i, w, h)
+				j.p = p
+				*coords = append(*coords, j)
+				i++
+			}
 		}
 
 	}
+	return nil
 }
 
-func (coords *Set) InitializeBig(cfg Config) {
-	halfwidth := new(stdbig.Float).SetPrec(precision).SetFloat64(cfg.PlotWidth)
+// NewSet creates a Set initialized according to cfg, using perturbation when
+// cfg.UsePerturbation() says the plot needs it.
+func NewSet(cfg Config) (Set, error) {
+	coords := make(Set, 0, cfg.XRes*cfg.YRes)
+	var err error
+	if cfg.UsePerturbation() {
+		err = coords.InitializePerturb(cfg)
+	} else {
+		err = coords.Initialize(cfg)
+	}
+	return coords, err
+}
+
+// InitializeBig sets up a Set of BigJobs according to the configuration
+// specified, with enough precision for the plot's zoom depth. BigJobs iterate
+// cfg.Expression if there is one, or else the Mandelbrot set, whatever
+// cfg.Formula is.
+func (coords *Set) InitializeBig(cfg Config) error {
+	prec, err := cfg.Precision()
+	if err != nil {
+		return err
+	}
+	halfwidth, err := cfg.Width(prec)
+	if err != nil {
+		return err
+	}
 	halfwidth.Quo(halfwidth, stdbig.NewFloat(2))
-	halfheight := new(stdbig.Float).SetPrec(precision).SetFloat64(cfg.PlotHeight)
+	halfheight, err := cfg.Height(prec)
+	if err != nil {
+		return err
+	}
 	halfheight.Quo(halfheight, stdbig.NewFloat(2))
-	centerReal := new(stdbig.Float).SetPrec(precision)
-	centerReal.Parse("-1.77810334274064037110522326038852639499207961414628307584575173232969154440", 0)
-	centerImag := new(stdbig.Float).SetPrec(precision)
-	centerImag.Parse("0.00767394242121339392672671947893471774958985018535019684946671264012302378", 0)
+	center, err := cfg.Center(prec)
+	if err != nil {
+		return err
+	}
+	centerReal, centerImag := &center.R, &center.I
 
 	left := new(stdbig.Float).Sub(centerReal, halfwidth)
 	right := new(stdbig.Float).Add(centerReal, halfwidth)
 	top := new(stdbig.Float).Add(centerImag, halfheight)
 	bottom := new(stdbig.Float).Sub(centerImag, halfheight)
 
+	p, err := cfg.Params()
+	if err != nil {
+		return err
+	}
 	yStep := new(stdbig.Float).Sub(top, bottom)
 	yStep.Quo(yStep, stdbig.NewFloat(float64(cfg.YRes)))
 	xStep := new(stdbig.Float).Sub(right, left)
@@ -197,6 +321,7 @@ func (coords *Set) InitializeBig(cfg Config) {
 			j.Index = i
 			j.X = w
 			j.Y = h
+			j.p = p
 			j.N = new(big.Complex)
 			j.N.R.Copy(x)
 			j.N.I.Copy(y)
@@ -208,44 +333,33 @@ func (coords *Set) InitializeBig(cfg Config) {
 		}
 		y.Sub(y, yStep)
 	}
-
+	return nil
 }
 
 // CalculateProgress performs `action` on all the coordinates in a Set.
 // The progress can be obtained by providing the address of a float64 in which
 // [0,1] will be written.
+//
+// Deprecated: reading *progress while it is written is a data race. Use
+// CalculateContext with CalcOptions.Progress.
 func (coords Set) CalculateProgress(iterations int, progress *float64) {
-	// concurrent implementation of actually computing mandelbrot set
-	//
-	// buffered input channel to hold values, 1 for each worker so none have
-	// to block while waiting for jobs
-	workers := runtime.NumCPU()
-	in := make(chan Job, workers)
-
-	// start workers
-	wg := sync.WaitGroup{}
-	wg.Add(workers)
-	for w := 0; w < workers; w++ {
-		go func(id int) {
-			defer wg.Done()
-			for j := range in {
-				j.RunMandelbrot(iterations)
-			}
-			// fmt.Printf("worker %d stopped.\n", id)
-		}(w)
-	}
-
-	// send jobs to workers
-	total := float64(len(coords))
-	for i, j := range coords {
-		in <- j // will block when buffered channel is full
-		if progress != nil {
-			*progress = float64(i+1) / total
-		}
+	var opts CalcOptions
+	if progress != nil {
+		opts.Progress = func(p Progress) { *progress = p.Fraction() }
 	}
+	coords.CalculateContext(context.Background(), iterations, opts)
+}
 
-	close(in) // close channel to stop workers
-	wg.Wait() // wait for all workers to finish (join)
+// CalculateContext performs `action` on all the coordinates in a Set,
+// reporting progress as set in opts. If ctx is cancelled, it stops soon after
+// with ctx.Err(), leaving some Jobs not run.
+func (coords Set) CalculateContext(ctx context.Context, iterations int, opts CalcOptions) error {
+	// concurrent implementation of actually computing mandelbrot set
+	m := newMeter(opts, len(coords), 0)
+	return forEach(ctx, len(coords), func(i int) {
+		coords[i].RunMandelbrot(iterations)
+		m.add(1, 0)
+	})
 }
 
 // Calculate performs `action` on all the coordinates in a Set.
@@ -253,42 +367,6 @@ func (coords Set) Calculate(iterations int) {
 	coords.CalculateProgress(iterations, nil)
 }
 
-// WriteData writes a Set to filename as a gob (go object serialization).
-func WriteData(coords Set, filename string) {
-	gob.Register(&BigJob{})
-	gob.Register(&C128Job{})
-	file, err := os.Create(filename)
-	defer file.Close()
-	if err != nil {
-		panic(err)
-	}
-
-	enc := gob.NewEncoder(file)
-	err = enc.Encode(coords)
-	if err != nil {
-		panic(err)
-	}
-}
-
-// ReadData reads a Set from filename.
-func ReadData(filename string) (coords Set) {
-	gob.Register(&BigJob{})
-	gob.Register(&C128Job{})
-	file, err := os.Open(filename)
-	defer file.Close()
-	if err != nil {
-		panic(err)
-	}
-
-	dec := gob.NewDecoder(file)
-	err = dec.Decode(&coords)
-	if err != nil {
-		panic(err)
-	}
-
-	return
-}
-
 // IsMemberMandelbrot runs the recurrent formula for the Mandelbrot set
 // for the given number of iterations, and returns if the complex number
 // `c` is in the set or not, as well as how many iterations it took to
@@ -311,17 +389,8 @@ func IsMemberMandelbrot(c complex128, iterations int) (bool, int) {
 // number of iterations, and returns if the complex number `z` is in the set
 // or not, as well as how many iterations it took to become 'infinity'.
 func IsMemberJulia(z complex128, c complex128, iterations int) (bool, int) {
-
-	for i, z := 0, z; i < iterations; i++ {
-		z = F(z, c, 2)
-		if cmplx.Abs(z) > 2.0 {
-			// went to infinity
-			return false, i
-		}
-	}
-
-	// did not go to "infinity"
-	return true, iterations
+	r := EscapeJulia(z, c, iterations, DefaultEscapeRadius)
+	return r.In, r.Iterations
 }
 
 // F is the general form of the recurrence function `F = z^exp + c` .
@@ -329,56 +398,63 @@ func F(z, c, exp complex128) complex128 {
 	return cmplx.Pow(z, exp) + c
 }
 
-//CreatePicture draws an image.RGBA image.Image from the points created above.
-func CreatePicture(coords Set, ramp []color.RGBA, width, height int, setColor color.RGBA) image.Image {
-
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+// Coloring modes for Config.Coloring.
+const (
+	ColorIterations      = "iterations"       // ramp color by iteration count
+	ColorSmooth          = "smooth"           // interpolate the ramp by smooth iteration count
+	ColorDistance        = "distance"         // ramp color by distance to the set
+	ColorPeriod          = "period"           // smooth coloring, with the inside colored by period
+	ColorHistogram       = "histogram"        // the ramp spread evenly over the points by iteration count
+	ColorHistogramSmooth = "histogram_smooth" // ColorHistogram by smooth iteration count
+	ColorBasin           = "basin"            // a ramp for each root of a Newton fractal, by convergence speed
+	ColorBuddha          = "buddha"           // a Buddhabrot's hits, by the ramp for one band, or as RGB for more
+	ColorTrap            = "trap"             // ramp color by the orbit's least distance to the traps
+)
 
-	workers := runtime.NumCPU()
-	in := make(chan Job, workers)
-	wg := sync.WaitGroup{}
-	wg.Add(workers)
+// periodStride spreads the colors of consecutive periods across the ramp
+// (as a fraction of its length) for ColorPeriod. It is the golden ratio, so
+// that no two small periods get similar colors.
+const periodStride = 0.6180339887498949
 
-	// start workers
-	for w := 0; w < workers; w++ {
-		go func(id int) {
-			for c := range in {
+// distanceFalloff is the distance, in pixels, over which ColorDistance moves
+// most of the way along the ramp.
+const distanceFalloff = 4.0
 
-				isIn, iterations, x, y := c.GetImageInfo()
+// basinFalloff is the number of steps of Newton's method over which
+// ColorBasin moves most of the way along a root's ramp.
+const basinFalloff = 12.0
 
-				if isIn {
-					img.SetRGBA(x, y, setColor)
-				} else {
-					img.SetRGBA(x, y, ramp[iterations%len(ramp)])
-				}
-			}
-			wg.Done()
-		}(w)
-	}
-
-	// send jobs to workers
-	for _, c := range coords {
-		in <- c
-	}
+// trapFalloff is the distance, in the plane, over which ColorTrap moves most
+// of the way along the ramp.
+const trapFalloff = 0.25
 
-	// join workers
-	close(in)
-	wg.Wait()
+//CreatePicture draws an image.RGBA image.Image from the points created above.
+// Pixels with several Jobs, as made by Initialize with Config.Supersample,
+// are the average of their colors, mixed in linear light. As with
+// Grid.Picture, the ramp mustn't be empty.
+func CreatePicture(coords Set, ramp []color.RGBA, width, height int, setColor color.RGBA) image.Image {
+	return coords.Grid(width, height).Picture(ramp, setColor)
+}
 
-	return img
+// Colorize draws an image like CreatePicture, but colors the points as
+// selected by cfg.Coloring. See Grid.Colorize.
+func Colorize(coords Set, ramp []color.RGBA, cfg Config) (image.Image, error) {
+	return coords.Grid(cfg.XRes, cfg.YRes).Colorize(ramp, cfg)
 }
 
-// OutputToJPG writes an image.Image to the given output filename
-func OutputToJPG(img image.Image, outputFilename string) {
+// OutputToJPG writes an image.Image to the given output filename, as a JPEG
+// of DefaultQuality. WriteImage writes other formats.
+func OutputToJPG(img image.Image, outputFilename string) error {
 	file, err := os.Create(outputFilename)
-	defer file.Close()
 	if err != nil {
-		panic(fmt.Errorf("error when opening '%s'", outputFilename))
+		return err
 	}
-	err = jpeg.Encode(file, img, &jpeg.Options{Quality: 98})
+	defer file.Close()
+	err = jpeg.Encode(file, img, &jpeg.Options{Quality: DefaultQuality})
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("writing image to %s: %w", outputFilename, err)
 	}
+	return file.Close()
 }
 
 // DEPRECATED
@@ -0,0 +1,234 @@
+package mandelbrot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+// Metadata records how an image was made, so that it can be made again or
+// explored further. WriteImage embeds it in the image, as JSON: in an iTXt
+// chunk of a PNG or COM segments of a JPEG, marked with metadataKey, or in
+// the ImageDescription of a TIFF. ReadMetadata gets it back.
+type Metadata struct {
+	Config Config `json:"config"`
+	// Ramps are the stops of the ramps used: those of RampFile, or of each
+	// of RampFiles in turn.
+	Ramps [][]Stop `json:"ramps,omitempty"`
+}
+
+// metadataKey is the PNG keyword of the chunk with the Metadata, and the
+// start of the JPEG comments with it.
+const metadataKey = "mandelbrot"
+
+// software is recorded as having made the images.
+const software = "mandelbrot"
+
+// maxComment is the most bytes a JPEG COM segment can hold, after its
+// length.
+const maxComment = 0xffff - 2
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// ReadMetadata reads the Metadata embedded in an image written by
+// WriteImage. The error wraps ErrBadFormat if the file isn't a PNG, JPEG or
+// TIFF, and ErrNoMetadata if it doesn't have any.
+func ReadMetadata(filename string) (*Metadata, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var text []byte
+	switch {
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		text, err = pngMetadata(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		text, err = jpegMetadata(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		text, err = tiffMetadata(data)
+	default:
+		err = fmt.Errorf("%w: not a PNG, JPEG or TIFF", ErrBadFormat)
+	}
+	if err == nil && text == nil {
+		err = ErrNoMetadata
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata from %s: %w", filename, err)
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(text, &meta); err != nil {
+		return nil, fmt.Errorf("reading metadata from %s: %w: %v", filename, ErrNoMetadata, err)
+	}
+	return &meta, nil
+}
+
+// ReadImageConfig reads the Config from the Metadata of an image, and
+// checks it with Validate, like ReadConfig.
+func ReadImageConfig(filename string) (Config, error) {
+	meta, err := ReadMetadata(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	c := meta.Config
+	if err := c.Validate(); err != nil {
+		return Config{}, fmt.Errorf("reading config from %s: %w", filename, err)
+	}
+	c.syncBig()
+	return c, nil
+}
+
+// addPNGMetadata inserts meta into the PNG in data, after its header chunk.
+//
+// Format from
+// https://www.w3.org/TR/png/#11iTXt
+func addPNGMetadata(data, meta []byte) []byte {
+	chunk := func(typ string, body ...[]byte) []byte {
+		b := []byte(typ)
+		for _, part := range body {
+			b = append(b, part...)
+		}
+		var c bytes.Buffer
+		binary.Write(&c, binary.BigEndian, uint32(len(b)-len(typ)))
+		c.Write(b)
+		binary.Write(&c, binary.BigEndian, crc32.ChecksumIEEE(b))
+		return c.Bytes()
+	}
+	// the signature, then IHDR's length, type, 13 bytes and CRC
+	const afterHeader = len(pngSignature) + 4 + 4 + 13 + 4
+	var out bytes.Buffer
+	out.Write(data[:afterHeader])
+	out.Write(chunk("tEXt", []byte("Software\x00"+software)))
+	// keyword, uncompressed, no language or translated keyword
+	out.Write(chunk("iTXt", []byte(metadataKey+"\x00\x00\x00\x00\x00"), meta))
+	out.Write(data[afterHeader:])
+	return out.Bytes()
+}
+
+// pngMetadata finds the text of the metadata chunk of the PNG in data, or
+// nil if it has none.
+func pngMetadata(data []byte) ([]byte, error) {
+	for rest := data[len(pngSignature):]; ; {
+		if len(rest) < 12 {
+			return nil, fmt.Errorf("%w: truncated PNG", ErrBadFormat)
+		}
+		n := int(binary.BigEndian.Uint32(rest))
+		if n < 0 || 12+n > len(rest) {
+			return nil, fmt.Errorf("%w: truncated PNG", ErrBadFormat)
+		}
+		typ, body := string(rest[4:8]), rest[8:8+n]
+		rest = rest[12+n:]
+		if typ == "IEND" {
+			return nil, nil
+		}
+		if typ != "iTXt" || !bytes.HasPrefix(body, []byte(metadataKey+"\x00")) {
+			continue
+		}
+		// skip the keyword and compression, then the language and
+		// translated keyword, which each end with a zero
+		body = body[len(metadataKey)+1:]
+		if len(body) < 2 || body[0] != 0 {
+			return nil, fmt.Errorf("%w: compressed metadata", ErrBadFormat)
+		}
+		body = body[2:]
+		for k := 0; k < 2; k++ {
+			i := bytes.IndexByte(body, 0)
+			if i < 0 {
+				return nil, fmt.Errorf("%w: bad iTXt chunk", ErrBadFormat)
+			}
+			body = body[i+1:]
+		}
+		return body, nil
+	}
+}
+
+// addJPEGMetadata inserts meta into the JPEG in data, as COM segments after
+// its start of image marker. Each holds metadataKey, a colon, and as much of
+// meta as fits.
+func addJPEGMetadata(data, meta []byte) []byte {
+	var out bytes.Buffer
+	out.Write(data[:2])
+	prefix := []byte(metadataKey + ":")
+	for len(meta) > 0 {
+		n := len(meta)
+		if n > maxComment-len(prefix) {
+			n = maxComment - len(prefix)
+		}
+		out.Write([]byte{0xff, 0xfe})
+		binary.Write(&out, binary.BigEndian, uint16(2+len(prefix)+n))
+		out.Write(prefix)
+		out.Write(meta[:n])
+		meta = meta[n:]
+	}
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+// jpegMetadata joins the metadata in the COM segments of the JPEG in data,
+// or returns nil if it has none.
+func jpegMetadata(data []byte) ([]byte, error) {
+	var text []byte
+	prefix := []byte(metadataKey + ":")
+	for rest := data[2:]; len(rest) >= 4 && rest[0] == 0xff; {
+		marker := rest[1]
+		if marker == 0xda || marker == 0xd9 {
+			// the start of the scan, after which there are no more comments
+			break
+		}
+		n := int(binary.BigEndian.Uint16(rest[2:]))
+		if n < 2 || 2+n > len(rest) {
+			return nil, fmt.Errorf("%w: truncated JPEG segment", ErrBadFormat)
+		}
+		if body := rest[4 : 2+n]; marker == 0xfe && bytes.HasPrefix(body, prefix) {
+			text = append(text, body[len(prefix):]...)
+		}
+		rest = rest[2+n:]
+	}
+	return text, nil
+}
+
+// tiffMetadata finds the ImageDescription of the TIFF in data, if it is
+// metadata, or returns nil if it isn't.
+func tiffMetadata(data []byte) ([]byte, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	truncated := fmt.Errorf("%w: truncated TIFF", ErrBadFormat)
+	if len(data) < 8 {
+		return nil, truncated
+	}
+	dir := int(order.Uint32(data[4:]))
+	if dir+2 > len(data) {
+		return nil, truncated
+	}
+	n := int(order.Uint16(data[dir:]))
+	if dir+2+12*n > len(data) {
+		return nil, truncated
+	}
+	for i := 0; i < n; i++ {
+		e := data[dir+2+12*i:]
+		if order.Uint16(e) != tiffImageDescription || order.Uint16(e[2:]) != tiffASCII {
+			continue
+		}
+		count := int(order.Uint32(e[4:]))
+		value := e[8:12]
+		if count > 4 {
+			offset := int(order.Uint32(e[8:]))
+			if offset+count > len(data) || offset < 0 {
+				return nil, truncated
+			}
+			value = data[offset : offset+count]
+		}
+		text := bytes.TrimRight(value[:count], "\x00")
+		if !bytes.HasPrefix(text, []byte("{")) {
+			return nil, nil
+		}
+		return text, nil
+	}
+	return nil, nil
+}
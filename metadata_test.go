@@ -0,0 +1,117 @@
+package mandelbrot
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	cfg := NewConfig()
+	cfg.BigCenterReal = "-1.7490036909496675436249785464592810341796875"
+	cfg.BigCenterImag = "0.00000000000000000000000000000000000000001"
+	cfg.BigPlotWidth = "1e-40"
+	cfg.Coloring, cfg.ImageFile = ColorSmooth, "deep.png"
+
+	// one ramp too long for one JPEG comment
+	var long []Stop
+	for i := 0; i < 3000; i++ {
+		long = append(long, Stop{Position: i, Color: fmt.Sprintf("%06X", i*5000), Space: SpaceOKLab})
+	}
+	metas := []*Metadata{
+		{Config: cfg, Ramps: [][]Stop{{{Color: "000000"}, {Position: 10, Color: "FFFFFF", Easing: EaseCubic}}}},
+		{Config: cfg, Ramps: [][]Stop{long}},
+	}
+
+	dir := t.TempDir()
+	for _, ext := range []string{".png", ".jpg", ".tiff"} {
+		for k, meta := range metas {
+			filename := filepath.Join(dir, fmt.Sprintf("%d%s", k, ext))
+			if err := WriteImage(img, filename, 0, meta); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadMetadata(filename)
+			if err != nil {
+				t.Fatalf("%s: %v", filename, err)
+			}
+			if !reflect.DeepEqual(got, meta) {
+				t.Errorf("%s: ReadMetadata() = %+v, want %+v", filename, got, meta)
+			}
+
+			// the image is still an image
+			if ext == ".tiff" {
+				continue
+			}
+			file, err := os.Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, _, err := image.Decode(file)
+			file.Close()
+			if err != nil || decoded.Bounds() != img.Bounds() {
+				t.Errorf("%s: decoded %v, %v", filename, decoded, err)
+			}
+		}
+	}
+
+	c, err := ReadImageConfig(filepath.Join(dir, "0.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if center, _ := cfg.Center(64); c.BigCenterReal != cfg.BigCenterReal || c.CenterReal != real(center.Complex128()) {
+		t.Errorf("ReadImageConfig() center = %s (%g), want %s", c.BigCenterReal, c.CenterReal, cfg.BigCenterReal)
+	}
+}
+
+func TestMetadataErrors(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for _, ext := range []string{".png", ".jpg", ".tif"} {
+		filename := filepath.Join(dir, "plain"+ext)
+		if err := WriteImage(img, filename, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadMetadata(filename); !errors.Is(err, ErrNoMetadata) {
+			t.Errorf("%s: error = %v, want ErrNoMetadata", ext, err)
+		}
+	}
+
+	filename := filepath.Join(dir, "config.json")
+	if err := WriteConfig(NewConfig(), filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMetadata(filename); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("json: error = %v, want ErrBadFormat", err)
+	}
+
+	// an image of a config which isn't valid
+	bad := NewConfig()
+	bad.XRes = 0
+	filename = filepath.Join(dir, "bad.png")
+	if err := WriteImage(img, filename, 0, &Metadata{Config: bad}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadImageConfig(filename); !errors.Is(err, ErrConfigInvalid) {
+		t.Errorf("ReadImageConfig() error = %v, want ErrConfigInvalid", err)
+	}
+
+	// truncated
+	data, err := ioutil.ReadFile(filepath.Join(dir, "plain.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, data[:40], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMetadata(filename); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("truncated: error = %v, want ErrBadFormat", err)
+	}
+}
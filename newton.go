@@ -0,0 +1,224 @@
+package mandelbrot
+
+import (
+	"fmt"
+	"mandelbrot/expr"
+	"math"
+	"math/cmplx"
+)
+
+// NewtonTolerance is how small a step of Newton's method must be for it to
+// have converged.
+const NewtonTolerance = 1e-9
+
+// maxRoots is the most roots a Newton fractal may have, so that they can be
+// numbered in a uint8.
+const maxRoots = 255
+
+// Newton is Newton's method for finding the roots of a polynomial, z' = z -
+// a p(z)/p'(z). Its fractal is made by iterating from each point until it
+// converges, and coloring the point by which root it reached, its basin, and
+// how quickly.
+type Newton struct {
+	// Coefficients of the polynomial, from the highest power of z down.
+	Coefficients []complex128
+	// Roots are the distinct roots of the polynomial, numbered from 1 in the
+	// Result in this order.
+	Roots []complex128
+	// Relaxation is the a multiplying each step; 1 is Newton's method itself.
+	Relaxation complex128
+}
+
+// NewNewton gets the Newton for the polynomial with the given coefficients,
+// from the highest power of z down, finding its roots. A relaxation of 0 is
+// 1. The error is a *ConfigError for the "coefficients" or "relaxation"
+// field.
+func NewNewton(coefficients []complex128, relaxation float64) (*Newton, error) {
+	// leading zeros don't change the polynomial
+	for len(coefficients) > 0 && coefficients[0] == 0 {
+		coefficients = coefficients[1:]
+	}
+	if len(coefficients) < 3 || len(coefficients) > maxRoots+1 {
+		return nil, &ConfigError{Field: "coefficients", Reason: fmt.Sprintf("must be a polynomial of degree 2 to %d", maxRoots)}
+	}
+	n, err := newNewton(coefficients, relaxation)
+	if err != nil {
+		return nil, err
+	}
+	n.Roots = distinct(findRoots(coefficients))
+	return n, nil
+}
+
+// NewNewtonRoots gets the Newton for the polynomial with the given roots. The
+// roots are numbered in the order given, once any repeats are removed. A
+// relaxation of 0 is 1. The error is a *ConfigError for the "roots" or
+// "relaxation" field.
+func NewNewtonRoots(roots []complex128, relaxation float64) (*Newton, error) {
+	if len(roots) < 2 || len(roots) > maxRoots {
+		return nil, &ConfigError{Field: "roots", Reason: fmt.Sprintf("must number 2 to %d", maxRoots)}
+	}
+	// multiply out (z - r1)(z - r2)...
+	coefficients := []complex128{1}
+	for _, r := range roots {
+		next := append(coefficients, 0)
+		for i := len(next) - 1; i > 0; i-- {
+			next[i] -= r * next[i-1]
+		}
+		coefficients = next
+	}
+	n, err := newNewton(coefficients, relaxation)
+	if err != nil {
+		return nil, err
+	}
+	n.Roots = distinct(roots)
+	return n, nil
+}
+
+func newNewton(coefficients []complex128, relaxation float64) (*Newton, error) {
+	if relaxation == 0 {
+		relaxation = 1
+	}
+	// steps of a or more overshoot so far that they can't converge
+	if !(relaxation > 0 && relaxation < 2) {
+		return nil, &ConfigError{Field: "relaxation", Reason: fmt.Sprintf("must be in (0, 2), not %g", relaxation)}
+	}
+	return &Newton{Coefficients: coefficients, Relaxation: complex(relaxation, 0)}, nil
+}
+
+// eval returns p(z) and p'(z), by Horner's method.
+func (n *Newton) eval(z complex128) (p, dp complex128) {
+	for _, a := range n.Coefficients {
+		dp = dp*z + p
+		p = p*z + a
+	}
+	return p, dp
+}
+
+// converge iterates Newton's method from z. Points which converge to a root
+// have its number in Result.Root, and the number of steps taken in
+// Iterations and Smooth. Points which don't converge, such as those caught
+// in a cycle, are In.
+func (n *Newton) converge(z complex128, iterations int) Result {
+	for i := 0; i < iterations; i++ {
+		p, dp := n.eval(z)
+		if dp == 0 {
+			// a critical point, from which there is no step
+			break
+		}
+		step := n.Relaxation * p / dp
+		z -= step
+		if d := cmplx.Abs(step); d < NewtonTolerance {
+			root := n.root(z)
+			if root == 0 {
+				break
+			}
+			// the step shrinks like d' = d^2 near a simple root, so the
+			// fraction of a step is how far d went below the tolerance
+			f := math.Log2(math.Log(d) / math.Log(NewtonTolerance))
+			return Result{
+				Iterations: i,
+				Smooth:     float64(i) + 1 - math.Max(0, math.Min(1, f)),
+				Root:       root}
+		}
+	}
+	return Result{In: true, Iterations: iterations}
+}
+
+// root returns the number of the root which z is at, or 0 if z isn't near
+// any of them.
+func (n *Newton) root(z complex128) int {
+	best, closest := 0, math.Inf(1)
+	for i, r := range n.Roots {
+		if d := cmplx.Abs(z - r); d < closest {
+			best, closest = i+1, d
+		}
+	}
+	// repeated roots are only found to about the square root of the
+	// tolerance, as convergence to them is slow
+	if closest > math.Sqrt(NewtonTolerance)*math.Max(1, cmplx.Abs(z)) {
+		return 0
+	}
+	return best
+}
+
+// findRoots finds all the roots of the polynomial with the given
+// coefficients, by the Durand-Kerner method.
+//
+// Math from
+// https://en.wikipedia.org/wiki/Durand%E2%80%93Kerner_method
+func findRoots(coefficients []complex128) []complex128 {
+	monic := make([]complex128, len(coefficients))
+	for i, a := range coefficients {
+		monic[i] = a / coefficients[0]
+	}
+	eval := func(z complex128) complex128 {
+		var p complex128
+		for _, a := range monic {
+			p = p*z + a
+		}
+		return p
+	}
+
+	// starting points which aren't symmetric, so they can't get stuck in a
+	// symmetric arrangement
+	roots := make([]complex128, len(monic)-1)
+	for i, w := 0, complex(1, 0); i < len(roots); i++ {
+		roots[i] = w
+		w *= 0.4 + 0.9i
+	}
+	for iter := 0; iter < 1000; iter++ {
+		change := 0.0
+		for i, r := range roots {
+			q := complex(1, 0)
+			for j, s := range roots {
+				if j != i {
+					q *= r - s
+				}
+			}
+			if q == 0 {
+				continue
+			}
+			step := eval(r) / q
+			roots[i] -= step
+			change = math.Max(change, cmplx.Abs(step))
+		}
+		if change < 1e-15 {
+			break
+		}
+	}
+	return roots
+}
+
+// distinct returns roots without those repeated, to within the accuracy
+// with which repeated roots can be found.
+func distinct(roots []complex128) []complex128 {
+	var d []complex128
+next:
+	for _, r := range roots {
+		for _, s := range d {
+			if cmplx.Abs(r-s) < 1e-6*math.Max(1, cmplx.Abs(r)) {
+				continue next
+			}
+		}
+		d = append(d, r)
+	}
+	return d
+}
+
+// parseComplex parses a constant complex number in the syntax of expr, such
+// as "-0.5 + 0.866i" or "exp(2i*pi/3)".
+func parseComplex(s string) (complex128, error) {
+	e, err := expr.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	f, err := e.Compile()
+	if err != nil {
+		return 0, err
+	}
+	z := f(nil)
+	if cmplx.IsNaN(z) || cmplx.IsInf(z) {
+		return 0, fmt.Errorf("'%s' isn't finite", s)
+	}
+	return z, nil
+}
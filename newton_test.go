@@ -0,0 +1,132 @@
+package mandelbrot
+
+import (
+	"errors"
+	"image/color"
+	"math/cmplx"
+	"testing"
+)
+
+func TestNewtonRoots(t *testing.T) {
+	// z^3 - 1, whose roots are the cube roots of unity
+	n, err := NewNewton([]complex128{0, 1, 0, 0, -1}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Coefficients) != 4 {
+		t.Errorf("Coefficients = %v, want leading zeros removed", n.Coefficients)
+	}
+	if len(n.Roots) != 3 {
+		t.Fatalf("Roots = %v, want 3", n.Roots)
+	}
+	for _, r := range n.Roots {
+		if cmplx.Abs(r*r*r-1) > 1e-12 {
+			t.Errorf("root %v isn't a cube root of 1", r)
+		}
+	}
+
+	// a repeated root is only numbered once
+	n, err = NewNewtonRoots([]complex128{1, 1i, 1, -2}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []complex128{1, 1i, -2}; len(n.Roots) != len(want) || n.Roots[1] != want[1] {
+		t.Errorf("Roots = %v, want %v", n.Roots, want)
+	}
+	if p, _ := n.eval(-2); p != 0 {
+		t.Errorf("p(-2) = %v, want 0", p)
+	}
+}
+
+func TestNewtonConverge(t *testing.T) {
+	roots := []complex128{1, 0.5 + 1i, -1i, -1.5}
+	for _, relaxation := range []float64{1, 0.7} {
+		n, err := NewNewtonRoots(roots, relaxation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := Params{Newton: n}
+		for i, r := range roots {
+			got := p.Escape(r+0.01-0.02i, 200)
+			if got.In || got.Root != i+1 {
+				t.Errorf("relaxation %g: Escape near %v = %+v, want root %d", relaxation, r, got, i+1)
+			}
+			if got.Smooth <= float64(got.Iterations) || got.Smooth > float64(got.Iterations)+1 {
+				t.Errorf("relaxation %g: Smooth = %g, want in (%d, %d]", relaxation, got.Smooth, got.Iterations, got.Iterations+1)
+			}
+		}
+
+		// further away takes longer
+		near, far := p.Escape(1.01, 200), p.Escape(1.3+0.2i, 200)
+		if near.Smooth >= far.Smooth {
+			t.Errorf("relaxation %g: Smooth near = %g, far = %g", relaxation, near.Smooth, far.Smooth)
+		}
+	}
+
+	// starting from a critical point, there is nowhere to go
+	n, _ := NewNewtonRoots([]complex128{1, -1}, 0)
+	p := Params{Newton: n}
+	if got := p.Escape(0, 100); !got.In || got.Root != 0 {
+		t.Errorf("Escape(0) = %+v, want in", got)
+	}
+}
+
+func TestNewtonGrid(t *testing.T) {
+	cfg := NewConfig()
+	cfg.XRes, cfg.YRes, cfg.Iterations = 60, 40, 100
+	cfg.PlotWidth, cfg.PlotHeight = 3, 2
+	cfg.Coefficients = []string{"1", "0", "0", "-1"}
+	cfg.Coloring = ColorBasin
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[uint8]bool{}
+	for _, r := range g.Root {
+		seen[r] = true
+	}
+	for r := uint8(1); r <= 3; r++ {
+		if !seen[r] {
+			t.Errorf("no point reached root %d", r)
+		}
+	}
+
+	// each basin gets its own ramp
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	ramps := [][]color.RGBA{{red, red}, {blue, blue}}
+	img, err := g.ColorizeBasins(ramps, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range g.Root {
+		want := map[uint8]color.RGBA{0: {0, 0, 0, 255}, 1: red, 2: blue, 3: red}[r]
+		if got := img.At(i%g.Width, i/g.Width); got != want {
+			t.Fatalf("pixel %d of root %d is %v, want %v", i, r, got, want)
+		}
+	}
+}
+
+func TestGetNewtonErrors(t *testing.T) {
+	for _, tt := range []struct {
+		roots, coefficients []string
+		relaxation          float64
+		field               string
+	}{
+		{[]string{"1"}, nil, 0, "roots"},
+		{[]string{"1", "2 +"}, nil, 0, "roots"},
+		{[]string{"1", "z"}, nil, 0, "roots"},
+		{nil, []string{"0", "1", "-1"}, 0, "coefficients"},
+		{nil, []string{"1", "0", "1/0"}, 0, "coefficients"},
+		{[]string{"1", "-1"}, []string{"1", "0", "-1"}, 0, "roots"},
+		{[]string{"1", "-1"}, nil, 2, "relaxation"},
+		{[]string{"1", "-1"}, nil, -0.5, "relaxation"},
+	} {
+		cfg := NewConfig()
+		cfg.Roots, cfg.Coefficients, cfg.Relaxation = tt.roots, tt.coefficients, tt.relaxation
+		var ce *ConfigError
+		if err := cfg.Validate(); !errors.As(err, &ce) || ce.Field != tt.field {
+			t.Errorf("Validate() with %q, %q, %g error = %v, want one for %s", tt.roots, tt.coefficients, tt.relaxation, err, tt.field)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package mandelbrot
+
+import (
+	"context"
+	"image"
+	"mandelbrot/big"
+	"math"
+	stdbig "math/big"
+)
+
+// glitchTolerance is how small |z|^2 may get relative to |Z|^2 before the
+// point is considered glitched. This is Pauldelbrot's criterion; see
+// http://www.fractalforums.com/announcements-and-news/pertubation-theory-glitches-improvement/
+const glitchTolerance = 1e-6
+
+// defaultMaxReferences is used when Config.MaxReferences is 0.
+const defaultMaxReferences = 32
+
+// Reference is a point whose orbit has been computed once at high precision.
+// The orbit of any nearby point can then be found by iterating only its tiny
+// offset (delta) from the reference orbit, which fits comfortably in a
+// complex128 even when the plot is far narrower than a complex128 can
+// resolve. This is what makes deep zooms run at near float64 speed.
+//
+// Math from
+// https://en.wikibooks.org/wiki/Fractals/Iterations_in_the_complex_plane/Mandelbrot_set/perturbation
+//
+// A single reference is a poor fit for points whose orbits behave very
+// differently from it, and those points come out as flat "glitched" blobs.
+// Iterate detects them, and Set.FixGlitches renders them again with new
+// references placed inside the blobs.
+type Reference struct {
+	C      *big.Complex // the reference point
+	Orbit  []complex128 // Z_n rounded to complex128, where Z_0 = C
+	Offset complex128   // offset of C from the plot center
+	Series *Series      // used to skip early iterations, if not nil
+	Radius float64      // escape radius
+
+	c complex128 // C rounded to complex128
+}
+
+// NewReference computes the orbit of c for up to the given number of
+// iterations, stopping early if it escapes the radius. The orbit is computed
+// with c's precision.
+func NewReference(c *big.Complex, iterations int, radius float64) *Reference {
+	r := &Reference{
+		C:      new(big.Complex).Copy(c),
+		Orbit:  make([]complex128, 0, iterations+1),
+		Radius: radius,
+		c:      c.Complex128()}
+
+	z := new(big.Complex).Copy(c)
+	realsq := new(stdbig.Float).SetPrec(c.Prec())
+	imagsq := new(stdbig.Float).SetPrec(c.Prec())
+	for i := 0; i <= iterations; i++ {
+		zc := z.Complex128()
+		r.Orbit = append(r.Orbit, zc)
+		if abs2(zc) > radius*radius {
+			break
+		}
+		// same algebra as BigJob.RunMandelbrot
+		realsq.Mul(&z.R, &z.R)
+		imagsq.Mul(&z.I, &z.I)
+		z.I.Mul(&z.R, &z.I)
+		z.I.Add(&z.I, &z.I)
+		z.I.Add(&z.I, &c.I)
+		z.R.Sub(realsq, imagsq)
+		z.R.Add(&z.R, &c.R)
+	}
+
+	return r
+}
+
+// Nearby computes a new Reference at the given offset from the plot center,
+// with the same precision as r.
+func (r *Reference) Nearby(offset complex128, iterations int) *Reference {
+	d := offset - r.Offset
+	c := new(big.Complex).Add(r.C, big.NewComplex(real(d), imag(d), r.C.Prec()))
+	nr := NewReference(c, iterations, r.Radius)
+	nr.Offset = offset
+	return nr
+}
+
+// Iterate runs the Mandelbrot recurrence for the point C+dc by perturbing the
+// reference orbit, and returns the Result.
+//
+// Periodicity is not checked, since the orbits of nearby points can't be told
+// apart in complex128, only their deltas.
+//
+// The point is glitched if the reference is a poor fit for it. When
+// res.Glitch is true, the rest of the Result is unreliable and the point
+// should be computed again with a different reference. `size` is |z|^2/|Z|^2 at the
+// moment the glitch was detected: the smaller it is, the closer the point is
+// to the "center" of the glitch.
+//
+// With z = Z + d, the recurrence z' = z^2 + c becomes
+//
+//	d' = 2Zd + d^2 + dc
+//
+// which only involves small numbers.
+func (r *Reference) Iterate(dc complex128, iterations int) (res Result, size float64) {
+	r2 := r.Radius * r.Radius
+	n := len(r.Orbit)
+	if n > iterations {
+		n = iterations
+	}
+
+	// dz is dz/dc for the distance estimate, which is the same as dd/dc
+	d, dz, i := dc, complex(1, 0), 0
+	if s := r.Series; s != nil && s.Skip < n {
+		d, dz, i = s.Delta(dc), s.Derivative(dc), s.Skip
+	}
+	for ; i < n; i++ {
+		Z := r.Orbit[i]
+		z2, Z2 := abs2(Z+d), abs2(Z)
+		if z2 > r2 {
+			return escaped(i, z2, r.Radius, dz), 0
+		}
+		if z2 < glitchTolerance*Z2 {
+			return Result{Iterations: i, Glitch: true}, z2 / Z2
+		}
+		dz = 2*(Z+d)*dz + 1
+		d = 2*Z*d + d*d + dc
+	}
+	if i == iterations {
+		return Result{In: true, Iterations: iterations}, 0
+	}
+
+	// The reference escaped before this point did, so there is nothing left
+	// to perturb and the point is glitched. Finish with plain complex128
+	// math, which is only approximate this deep, so that the point has a
+	// sensible value if the glitch is never fixed.
+	Z := r.Orbit[i-1]
+	z, c := Z*Z+r.c+d, r.c+dc
+	for ; i < iterations; i++ {
+		if a := abs2(z); a > r2 {
+			res = escaped(i, a, r.Radius, dz)
+			res.Glitch = true
+			return res, 1
+		}
+		dz = 2*z*dz + 1
+		z = z*z + c
+	}
+
+	return Result{In: true, Iterations: iterations, Glitch: true}, 1
+}
+
+// PerturbJob is a Job which computes its point as an offset from a shared
+// Reference.
+type PerturbJob struct {
+	Delta      complex128 // offset of the point from the plot center
+	In         bool
+	Iterations int
+	Abs        float64
+	Smooth     float64
+	Distance   float64
+	Glitch     bool // the reference was a poor fit for this point
+	Index      int
+	X, Y       int
+
+	ref        *Reference
+	glitchSize float64
+}
+
+// NewPerturbJob creates a job for the point at delta from the plot center.
+func NewPerturbJob(ref *Reference, delta complex128, index, x, y int) *PerturbJob {
+	return &PerturbJob{
+		Delta: delta,
+		Index: index,
+		X:     x,
+		Y:     y,
+		ref:   ref}
+}
+
+// RunMandelbrot determines if the job's point is in the Mandelbrot set.
+func (j *PerturbJob) RunMandelbrot(iterations int) {
+	var r Result
+	r, j.glitchSize = j.ref.Iterate(j.Delta-j.ref.Offset, iterations)
+	j.In, j.Iterations, j.Abs, j.Smooth, j.Distance, j.Glitch = r.In, r.Iterations, r.Abs, r.Smooth, r.Distance, r.Glitch
+}
+
+// GetImageInfo returns information needed to draw the point.
+func (j *PerturbJob) GetImageInfo() (bool, int, int, int) {
+	return j.In, j.Iterations, j.X, j.Y
+}
+
+// GetResult returns the result of RunMandelbrot.
+func (j *PerturbJob) GetResult() Result {
+	return Result{In: j.In, Iterations: j.Iterations, Abs: j.Abs, Smooth: j.Smooth, Distance: j.Distance, Glitch: j.Glitch}
+}
+
+// InitializePerturb sets up a Set according to cfg, like Initialize, but with
+// PerturbJobs that share a single reference orbit at the plot's center.
+func (coords *Set) InitializePerturb(cfg Config) error {
+	pl, err := newPerturbPlotter(cfg)
+	if err != nil {
+		return err
+	}
+	for i, h := 0, 0; h < cfg.YRes; h++ {
+		for w := 0; w < cfg.XRes; w++ {
+			*coords = append(*coords, NewPerturbJob(pl.ref, pl.delta(w, h), i, w, h))
+			i++
+		}
+	}
+	return nil
+}
+
+// perturbPlotter computes pixels with perturbation, starting with a single
+// reference orbit at the plot's center.
+type perturbPlotter struct {
+	ref          *Reference
+	left, top    float64 // offset of the top left pixel from the center
+	xStep, yStep float64
+	width        int
+	iterations   int
+	samples      sampler
+	sizes        []float64    // glitch size of each pixel if allocated, see Reference.Iterate
+	refs         []*Reference // reference last used for each pixel if allocated, for its samples
+	worst        []complex128 // delta of the most glitched of each pixel and its samples, with refs
+}
+
+func newPerturbPlotter(cfg Config) (*perturbPlotter, error) {
+	prec, err := cfg.Precision()
+	if err != nil {
+		return nil, err
+	}
+	center, err := cfg.Center(prec)
+	if err != nil {
+		return nil, err
+	}
+	p, err := cfg.Params()
+	if err != nil {
+		return nil, err
+	}
+	ref := NewReference(center, cfg.Iterations, p.radius())
+
+	// offsets from the center are small, so float64 is plenty
+	left, top := -cfg.PlotWidth/2, cfg.PlotHeight/2
+	if cfg.SeriesTerms > 0 {
+		probes := []complex128{
+			complex(left, top), complex(0, top), complex(-left, top),
+			complex(left, 0), complex(-left, 0),
+			complex(left, -top), complex(0, -top), complex(-left, -top)}
+		ref.Series = NewSeries(ref, cfg.SeriesTerms, math.Hypot(left, top), probes)
+	}
+
+	return &perturbPlotter{
+		ref:        ref,
+		left:       left,
+		top:        top,
+		xStep:      cfg.PlotWidth / float64(cfg.XRes),
+		yStep:      cfg.PlotHeight / float64(cfg.YRes),
+		width:      cfg.XRes,
+		iterations: cfg.Iterations,
+		samples:    newSampler(cfg)}, nil
+}
+
+// delta returns the offset of pixel (x,y) from the plot center.
+func (pl *perturbPlotter) delta(x, y int) complex128 {
+	return pl.deltaAt(float64(x), float64(y))
+}
+
+// deltaAt returns the offset from the plot center of the point at (x,y) in
+// pixels, which may be between pixels.
+func (pl *perturbPlotter) deltaAt(x, y float64) complex128 {
+	return complex(pl.left+x*pl.xStep, pl.top-y*pl.yStep)
+}
+
+func (pl *perturbPlotter) plot(x, y int) Result {
+	return pl.plotWith(pl.ref, x, y)
+}
+
+// plotWith computes pixel (x,y) using the given reference.
+func (pl *perturbPlotter) plotWith(ref *Reference, x, y int) Result {
+	r, size := ref.Iterate(pl.delta(x, y)-ref.Offset, pl.iterations)
+	pl.sizes[y*pl.width+x] = size
+	if pl.refs != nil {
+		pl.refs[y*pl.width+x] = ref
+		pl.worst[y*pl.width+x] = pl.delta(x, y)
+	}
+	return r
+}
+
+// sample computes a point near pixel (x,y) with the reference which was last
+// used for the pixel, which is likely to fit the point if it fit the pixel.
+func (pl *perturbPlotter) sample(x, y int, dx, dy float64) Result {
+	ref := pl.ref
+	if pl.refs != nil && pl.refs[y*pl.width+x] != nil {
+		ref = pl.refs[y*pl.width+x]
+	}
+	return pl.sampleWith(ref, x, y, dx, dy)
+}
+
+// sampleWith computes the point at (dx,dy) from pixel (x,y) using the given
+// reference. If it is glitched, and more so than the pixel and its other
+// samples, the pixel's glitch size and worst point are the sample's, so that
+// fixGlitches places a reference there.
+func (pl *perturbPlotter) sampleWith(ref *Reference, x, y int, dx, dy float64) Result {
+	delta := pl.deltaAt(float64(x)+dx, float64(y)+dy)
+	r, size := ref.Iterate(delta-ref.Offset, pl.iterations)
+	if i := y*pl.width + x; r.Glitch && (pl.sizes[i] == 0 || size < pl.sizes[i]) {
+		pl.sizes[i], pl.worst[i] = size, delta
+	}
+	return r
+}
+
+// fixGlitches does the same as Set.FixGlitches for a Grid computed by pl,
+// where refs references are already in use, stopping with ctx.Err() if ctx is
+// cancelled. A pixel is glitched if any of its samples are, and only what is
+// glitched is computed again.
+func (pl *perturbPlotter) fixGlitches(ctx context.Context, g *Grid, refs, maxRefs int) (int, error) {
+	if maxRefs <= 0 {
+		maxRefs = defaultMaxReferences
+	}
+
+	for ; refs < maxRefs; refs++ {
+		var points []image.Point
+		for i := 0; i < g.Width*g.Height; i++ {
+			if g.glitched(i) {
+				points = append(points, image.Pt(i%g.Width, i/g.Width))
+			}
+		}
+		if len(points) == 0 {
+			break
+		}
+
+		blob := largestBlob(points)
+		center := blob[0]
+		for _, p := range blob {
+			if pl.sizes[p.Y*g.Width+p.X] < pl.sizes[center.Y*g.Width+center.X] {
+				center = p
+			}
+		}
+
+		delta := pl.delta(center.X, center.Y)
+		if pl.worst != nil {
+			delta = pl.worst[center.Y*g.Width+center.X]
+		}
+		ref := pl.ref.Nearby(delta, pl.iterations)
+		err := forEach(ctx, len(blob), func(i int) {
+			p := blob[i]
+			pixel := p.Y*g.Width + p.X
+			if g.Flags[pixel]&FlagGlitch != 0 {
+				g.SetResult(p.X, p.Y, pl.plotWith(ref, p.X, p.Y))
+			}
+			if g.Samples == nil {
+				return
+			}
+			if g.Flags[pixel]&FlagGlitch == 0 {
+				// its glitch size is now that of its samples
+				pl.sizes[pixel] = 0
+			}
+			first := g.Samples[pixel]
+			for j := first; j < g.Samples[pixel+1]; j++ {
+				if g.Flags[j]&FlagGlitch != 0 {
+					dx, dy := pl.samples.offset(pixel, int(j-first))
+					g.set(int(j), pl.sampleWith(ref, p.X, p.Y, dx, dy))
+				}
+			}
+		})
+		if err != nil {
+			return refs, err
+		}
+	}
+
+	return refs, nil
+}
+
+// FixGlitches renders the glitched PerturbJobs in coords again, one blob of
+// adjacent glitched points at a time (largest first), each with a new
+// reference placed at the blob's most glitched point. This repeats until no
+// glitches remain or maxRefs references are in use (0 means a reasonable
+// default). It returns the number of references used, which is 0 if coords
+// has no PerturbJobs.
+func (coords Set) FixGlitches(iterations, maxRefs int) int {
+	if maxRefs <= 0 {
+		maxRefs = defaultMaxReferences
+	}
+
+	refs := map[*Reference]bool{}
+	for _, j := range coords {
+		if pj, ok := j.(*PerturbJob); ok {
+			refs[pj.ref] = true
+		}
+	}
+
+	for len(refs) > 0 && len(refs) < maxRefs {
+		glitched := map[image.Point]*PerturbJob{}
+		var points []image.Point
+		for _, j := range coords {
+			if pj, ok := j.(*PerturbJob); ok && pj.Glitch {
+				glitched[image.Pt(pj.X, pj.Y)] = pj
+				points = append(points, image.Pt(pj.X, pj.Y))
+			}
+		}
+		if len(points) == 0 {
+			break
+		}
+
+		blob := largestBlob(points)
+		center := glitched[blob[0]]
+		for _, p := range blob {
+			if j := glitched[p]; j.glitchSize < center.glitchSize {
+				center = j
+			}
+		}
+
+		ref := center.ref.Nearby(center.Delta, iterations)
+		refs[ref] = true
+		redo := make(Set, len(blob))
+		for i, p := range blob {
+			j := glitched[p]
+			j.ref = ref
+			redo[i] = j
+		}
+		redo.Calculate(iterations)
+	}
+
+	return len(refs)
+}
+
+// largestBlob finds the largest group of points which are connected
+// horizontally or vertically.
+func largestBlob(points []image.Point) (largest []image.Point) {
+	unvisited := make(map[image.Point]bool, len(points))
+	for _, p := range points {
+		unvisited[p] = true
+	}
+
+	neighbors := []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	for _, start := range points {
+		if !unvisited[start] {
+			continue
+		}
+		delete(unvisited, start)
+
+		// flood fill
+		blob, queue := []image.Point{}, []image.Point{start}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			blob = append(blob, cur)
+			for _, n := range neighbors {
+				if p := cur.Add(n); unvisited[p] {
+					delete(unvisited, p)
+					queue = append(queue, p)
+				}
+			}
+		}
+		if len(blob) > len(largest) {
+			largest = blob
+		}
+	}
+
+	return
+}
+
+// abs2 returns |z|^2.
+func abs2(z complex128) float64 {
+	return real(z)*real(z) + imag(z)*imag(z)
+}
@@ -0,0 +1,114 @@
+package mandelbrot
+
+import (
+	"mandelbrot/big"
+	"math/cmplx"
+	"testing"
+)
+
+func TestReferenceIterate(t *testing.T) {
+	center := big.NewComplex(-0.743643887037151, 0.131825904205330, precision)
+	ref := NewReference(center, 2000, DefaultEscapeRadius)
+
+	deltas := []complex128{0, 1e-9, -3e-9 + 2e-9i, 5e-8i, 1e-7 - 1e-7i}
+	for _, d := range deltas {
+		j := &BigJob{N: new(big.Complex).Add(center, big.NewComplex(real(d), imag(d), precision))}
+		j.RunMandelbrot(2000)
+
+		r, _ := ref.Iterate(d, 2000)
+		if r.Glitch {
+			t.Errorf("Iterate(%v) glitched", d)
+			continue
+		}
+		if r.In != j.In || r.Iterations != j.Iterations {
+			t.Errorf("Iterate(%v) = %v, %d, want %v, %d", d, r.In, r.Iterations, j.In, j.Iterations)
+		}
+	}
+}
+
+func TestFixGlitches(t *testing.T) {
+	cfg := NewConfig()
+	cfg.CenterReal, cfg.CenterImag = -0.75, 0.1 // escapes quickly
+	cfg.PlotWidth, cfg.PlotHeight = 0.01, 0.01
+	cfg.XRes, cfg.YRes = 24, 24
+	cfg.Iterations = 500
+
+	coords := Set{}
+	if err := coords.InitializePerturb(cfg); err != nil {
+		t.Fatal(err)
+	}
+	coords.Calculate(cfg.Iterations)
+	refs := coords.FixGlitches(cfg.Iterations, 1000)
+	if refs < 2 {
+		t.Errorf("FixGlitches() used %d references, want more than 1", refs)
+	}
+
+	center := big.NewComplex(cfg.CenterReal, cfg.CenterImag, precision)
+	for _, j := range coords {
+		pj := j.(*PerturbJob)
+		if pj.Glitch {
+			t.Fatalf("(%d,%d) still glitched", pj.X, pj.Y)
+		}
+		bj := &BigJob{N: new(big.Complex).Add(center, big.NewComplex(real(pj.Delta), imag(pj.Delta), precision))}
+		bj.RunMandelbrot(cfg.Iterations)
+		if bj.In != pj.In || bj.Iterations != pj.Iterations {
+			t.Errorf("(%d,%d) = %v, %d, want %v, %d", pj.X, pj.Y, pj.In, pj.Iterations, bj.In, bj.Iterations)
+		}
+	}
+}
+
+func TestNewSeries(t *testing.T) {
+	const radius = 1e-11
+	center := big.NewComplex(-0.743643887037151, 0.131825904205330, precision)
+	ref := NewReference(center, 20000, DefaultEscapeRadius)
+	probes := []complex128{complex(radius, 0), complex(0, -radius), complex(-radius, radius) * 0.7}
+	s := NewSeries(ref, 8, radius, probes)
+	if s == nil || s.Skip == 0 {
+		t.Fatal("NewSeries() skips no iterations")
+	}
+
+	for _, dc := range []complex128{0.3e-11, -0.5e-11i, 0.1e-11 + 0.2e-11i} {
+		d := dc
+		for i := 0; i < s.Skip; i++ {
+			d = 2*ref.Orbit[i]*d + d*d + dc
+		}
+		if got := s.Delta(dc); cmplx.Abs(got-d) > probeTolerance*cmplx.Abs(d) {
+			t.Errorf("Delta(%v) = %v, want %v", dc, got, d)
+		}
+	}
+}
+
+func TestDeepZoom(t *testing.T) {
+	cfg := NewConfig()
+	cfg.BigCenterReal = "-1.77810334274064037110522326038852639499207961414628307584575173232969154440"
+	cfg.BigCenterImag = "0.00767394242121339392672671947893471774958985018535019684946671264012302378"
+	cfg.BigPlotWidth = "1e-40"
+	cfg.XRes, cfg.YRes = 8, 8
+	cfg.Iterations = 5000
+	cfg.syncBig()
+
+	if !cfg.UsePerturbation() {
+		t.Fatal("UsePerturbation() = false for a 1e-40 wide plot")
+	}
+
+	want := Set{}
+	if err := want.InitializeBig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	want.Calculate(cfg.Iterations)
+
+	got := Set{}
+	if err := got.InitializePerturb(cfg); err != nil {
+		t.Fatal(err)
+	}
+	got.Calculate(cfg.Iterations)
+	got.FixGlitches(cfg.Iterations, 0)
+
+	for i := range want {
+		wIn, wIter, x, y := want[i].GetImageInfo()
+		gIn, gIter, _, _ := got[i].GetImageInfo()
+		if wIn != gIn || wIter != gIter {
+			t.Errorf("(%d,%d) = %v, %d, want %v, %d", x, y, gIn, gIter, wIn, wIter)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package mandelbrot
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress describes how much of a calculation is done. For a Buddhabrot,
+// the Pixels are the points sampled. With Config.Supersample, they include
+// the samples of the pixels, although those of an adaptive plot are only
+// added to TotalPixels once the pixels are done.
+type Progress struct {
+	Pixels, TotalPixels int
+	// Rows are only counted for a Grid. They are 0 for a Set.
+	Rows, TotalRows int
+	Elapsed         time.Duration
+	ETA             time.Duration // estimate of the time remaining
+}
+
+// Fraction is the fraction of the pixels done, in [0,1].
+func (p Progress) Fraction() float64 {
+	if p.TotalPixels == 0 {
+		return 1
+	}
+	return float64(p.Pixels) / float64(p.TotalPixels)
+}
+
+// CalcOptions are options for Grid.CalculateContext and Set.CalculateContext.
+type CalcOptions struct {
+	// Progress, if not nil, is called as the calculation proceeds, including
+	// when every pixel is done. Calls are never concurrent, but come from the
+	// calculation's workers, so should be quick.
+	Progress func(Progress)
+	// Interval is the least time between calls of Progress. 0 calls it
+	// whenever a row (or Job, for a Set) is done.
+	Interval time.Duration
+
+	// Checkpoint, if not empty, is the file to which the rows of a Grid are
+	// saved as they are done, every CheckpointInterval (or
+	// DefaultCheckpointInterval if it's 0), and when the calculation is
+	// cancelled. The file is left for the caller to remove once the results
+	// are safely stored. Sets aren't checkpointed. See CheckpointFile.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	// Resume makes the calculation skip the rows already done in the
+	// Checkpoint file, if it exists. The file must be for the same plot.
+	Resume bool
+}
+
+// meter counts the work done by a calculation, and reports it through
+// CalcOptions.Progress.
+type meter struct {
+	opts  CalcOptions
+	start time.Time
+	last  time.Time // of the last report
+
+	mu      sync.Mutex
+	p       Progress
+	skipped int // pixels done before the start, which don't count for the ETA
+}
+
+func newMeter(opts CalcOptions, pixels, rows int) *meter {
+	now := time.Now()
+	return &meter{
+		opts:  opts,
+		start: now,
+		last:  now,
+		p:     Progress{TotalPixels: pixels, TotalRows: rows}}
+}
+
+// skip records that pixels and rows were done before the calculation
+// started.
+func (m *meter) skip(pixels, rows int) {
+	m.p.Pixels += pixels
+	m.p.Rows += rows
+	m.skipped += pixels
+}
+
+// more records that there are pixels more to do than first thought.
+func (m *meter) more(pixels int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.p.TotalPixels += pixels
+}
+
+// add records that pixels and rows more are done, reporting the progress if
+// Interval has passed or everything is done.
+func (m *meter) add(pixels, rows int) {
+	if m == nil || m.opts.Progress == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.p.Pixels += pixels
+	m.p.Rows += rows
+
+	now := time.Now()
+	if now.Sub(m.last) < m.opts.Interval && m.p.Pixels < m.p.TotalPixels {
+		return
+	}
+	m.last = now
+	m.p.Elapsed = now.Sub(m.start)
+	m.p.ETA = 0
+	if done := m.p.Pixels - m.skipped; done > 0 {
+		left := float64(m.p.TotalPixels-m.p.Pixels) / float64(done)
+		m.p.ETA = time.Duration(float64(m.p.Elapsed) * left)
+	}
+	m.opts.Progress(m.p)
+}
@@ -0,0 +1,131 @@
+package mandelbrot
+
+import "math/cmplx"
+
+// seriesTolerance is how large the last term of a Series may be, relative to
+// the first, before the series is considered too inaccurate to use.
+const seriesTolerance = 1e-9
+
+// probeTolerance is the largest relative difference allowed between a Series
+// and directly iterated deltas at the probe points.
+const probeTolerance = 1e-6
+
+// Series approximates the perturbation delta after Skip iterations as a
+// truncated Taylor series in the pixel offset,
+//
+//	d_Skip = A_1 dc + A_2 dc^2 + ... + A_K dc^K
+//
+// so that every pixel can start iterating at Skip instead of 0. At deep zoom
+// the early iterations of all pixels are nearly identical, so this skips a
+// large part of the work.
+//
+// The coefficients are stored scaled by Radius^k, both to keep them from
+// overflowing and so that their magnitudes directly give the size of each
+// term at the edge of the plot.
+type Series struct {
+	Skip   int     // number of iterations the series replaces
+	Radius float64 // largest |dc| the series is valid for
+
+	coeffs []complex128 // A_k * Radius^k, for k = 1..K
+}
+
+// NewSeries finds how many iterations of ref can be skipped by a series with
+// the given number of terms, for offsets up to radius. The skip is limited
+// first by the size of the last term, then checked against deltas iterated
+// directly for the probe offsets, and reduced until they agree. It returns
+// nil if no iterations can be skipped.
+func NewSeries(ref *Reference, terms int, radius float64, probes []complex128) *Series {
+	if terms < 1 || radius <= 0 {
+		return nil
+	}
+
+	// largest skip the series' truncation error allows
+	s := &Series{Radius: radius, coeffs: make([]complex128, terms)}
+	s.coeffs[0] = complex(radius, 0)
+	next := make([]complex128, terms)
+	for s.Skip < len(ref.Orbit)-1 {
+		s.step(ref.Orbit[s.Skip], next)
+		if cmplx.Abs(next[terms-1]) > seriesTolerance*cmplx.Abs(next[0]) {
+			break
+		}
+		s.coeffs, next = next, s.coeffs
+		s.Skip++
+	}
+
+	// back off until the probes agree
+	for s.Skip > 0 {
+		if s.valid(ref, probes) {
+			return s
+		}
+		s = seriesAt(ref, terms, radius, s.Skip*3/4)
+	}
+
+	return nil
+}
+
+// seriesAt computes the series coefficients for exactly skip iterations.
+func seriesAt(ref *Reference, terms int, radius float64, skip int) *Series {
+	s := &Series{Radius: radius, coeffs: make([]complex128, terms)}
+	s.coeffs[0] = complex(radius, 0)
+	next := make([]complex128, terms)
+	for s.Skip < skip {
+		s.step(ref.Orbit[s.Skip], next)
+		s.coeffs, next = next, s.coeffs
+		s.Skip++
+	}
+	return s
+}
+
+// step advances the coefficients by one iteration of d' = 2Zd + d^2 + dc,
+// writing them to next. Matching powers of dc gives
+//
+//	A_k' = 2Z A_k + sum(A_i A_(k-i), i = 1..k-1) + (1 if k == 1)
+func (s *Series) step(Z complex128, next []complex128) {
+	for k := range s.coeffs {
+		a := 2 * Z * s.coeffs[k]
+		for i := 0; i < k; i++ {
+			a += s.coeffs[i] * s.coeffs[k-1-i]
+		}
+		next[k] = a
+	}
+	next[0] += complex(s.Radius, 0)
+}
+
+// valid checks the series against deltas iterated directly from ref.
+func (s *Series) valid(ref *Reference, probes []complex128) bool {
+	for _, dc := range probes {
+		d := dc
+		for i := 0; i < s.Skip; i++ {
+			Z := ref.Orbit[i]
+			d = 2*Z*d + d*d + dc
+		}
+		if cmplx.Abs(s.Delta(dc)-d) > probeTolerance*cmplx.Abs(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// Delta evaluates the series for the offset dc, giving the delta after Skip
+// iterations.
+func (s *Series) Delta(dc complex128) complex128 {
+	u := dc / complex(s.Radius, 0)
+	sum, p := complex(0, 0), u
+	for _, a := range s.coeffs {
+		sum += a * p
+		p *= u
+	}
+	return sum
+}
+
+// Derivative evaluates the derivative of the series with respect to dc,
+// which is the derivative of the delta after Skip iterations.
+func (s *Series) Derivative(dc complex128) complex128 {
+	u := dc / complex(s.Radius, 0)
+	sum, p := complex(0, 0), complex(1/s.Radius, 0)
+	for k, a := range s.coeffs {
+		sum += complex(float64(k+1), 0) * a * p
+		p *= u
+	}
+	return sum
+}
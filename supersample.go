@@ -0,0 +1,168 @@
+package mandelbrot
+
+import (
+	"context"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Sample patterns for Config.SamplePattern, which place the samples of a
+// supersampled pixel.
+const (
+	SampleGrid   = "grid"   // at the centers of the cells of an even grid
+	SampleJitter = "jitter" // at random within each cell, trading moiré for noise
+)
+
+// maxSupersample is the most samples across a pixel, making 256 in all.
+const maxSupersample = 16
+
+// sampler places the samples within the pixels of a plot.
+type sampler struct {
+	n      int // samples across and down a pixel
+	jitter bool
+	seed   int64
+}
+
+func newSampler(cfg Config) sampler {
+	return sampler{n: cfg.Supersample, jitter: cfg.SamplePattern == SampleJitter, seed: cfg.Seed}
+}
+
+// samples returns the number of samples of a supersampled pixel, or 0 if the
+// pixels aren't supersampled.
+func (s sampler) samples() int {
+	if s.n <= 1 {
+		return 0
+	}
+	return s.n * s.n
+}
+
+// offset returns where sample k of pixel i is, in pixels from the pixel's
+// point. The samples cover the pixel's square around its point, each in its
+// own cell of an n by n grid. With jitter, they are anywhere in their cells,
+// but always in the same place for the same seed.
+func (s sampler) offset(i, k int) (dx, dy float64) {
+	u, v := 0.5, 0.5
+	if s.jitter {
+		h := uint64(mix(s.seed, int64(i*s.samples()+k)))
+		u, v = float64(h>>40)/(1<<24), float64(h&(1<<24-1))/(1<<24)
+	}
+	n := float64(s.n)
+	return (float64(k%s.n)+u)/n - 0.5, (float64(k/s.n)+v)/n - 0.5
+}
+
+// sampleOffsets makes the Samples of a Grid of n pixels, where pixel i has
+// count(i) samples.
+func sampleOffsets(n int, count func(i int) int) []uint32 {
+	samples := make([]uint32, n+1)
+	samples[0] = uint32(n)
+	for i := 0; i < n; i++ {
+		samples[i+1] = samples[i] + uint32(count(i))
+	}
+	return samples
+}
+
+// setSamples sets the Samples of the Grid, resizing its slices to hold them,
+// with the samples zero. nil removes them.
+func (g *Grid) setSamples(samples []uint32) {
+	n := g.Width * g.Height
+	extra := 0
+	if samples != nil {
+		extra = int(samples[n]) - n
+	}
+	g.Samples = samples
+	g.Iterations = append(g.Iterations[:n], make([]uint32, extra)...)
+	g.Smooth = append(g.Smooth[:n], make([]float64, extra)...)
+	g.Distance = append(g.Distance[:n], make([]float32, extra)...)
+	g.Period = append(g.Period[:n], make([]uint32, extra)...)
+	g.Flags = append(g.Flags[:n], make([]uint8, extra)...)
+	g.Root = append(g.Root[:n], make([]uint8, extra)...)
+	g.Trap = append(g.Trap[:n], make([]float32, extra)...)
+}
+
+// supersample computes the samples of the pixels with pl, if cfg
+// supersamples them: all of them, or with cfg.Adaptive, those at edges. If
+// ctx is cancelled, it stops with ctx.Err().
+func (g *Grid) supersample(ctx context.Context, cfg Config, pl plotter, m *meter) error {
+	s := newSampler(cfg)
+	k := s.samples()
+	if k == 0 {
+		return nil
+	}
+	n := g.Width * g.Height
+	g.setSamples(sampleOffsets(n, func(i int) int {
+		if cfg.Adaptive > 0 && !g.edge(i, cfg.Adaptive) {
+			return 0
+		}
+		return k
+	}))
+	if cfg.Adaptive > 0 {
+		m.more(int(g.Samples[n]) - n)
+	}
+
+	return forEach(ctx, g.Height, func(y int) {
+		done := 0
+		for x, i := 0, y*g.Width; x < g.Width; x, i = x+1, i+1 {
+			first := g.Samples[i]
+			for j := first; j < g.Samples[i+1]; j++ {
+				dx, dy := s.offset(i, int(j-first))
+				g.set(int(j), pl.sample(x, y, dx, dy))
+				done++
+			}
+		}
+		m.add(done, 0)
+	})
+}
+
+// edge reports if pixel i differs from any of the pixels beside it, above or
+// below it by more than threshold smooth iterations, in being in the set, or
+// in the root it reached.
+func (g *Grid) edge(i int, threshold float64) bool {
+	x, y := i%g.Width, i/g.Width
+	differs := func(j int) bool {
+		in := g.Flags[i] & FlagIn
+		switch {
+		case in != g.Flags[j]&FlagIn, g.Root[i] != g.Root[j]:
+			return true
+		case in != 0:
+			return false
+		}
+		return math.Abs(g.Smooth[i]-g.Smooth[j]) > threshold
+	}
+	return x > 0 && differs(i-1) || x < g.Width-1 && differs(i+1) ||
+		y > 0 && differs(i-g.Width) || y < g.Height-1 && differs(i+g.Width)
+}
+
+// linear maps 16 bit sRGB components to linear light, in which samples are
+// averaged, as light mixes. It is made by linearOnce.
+var (
+	linear     []float32
+	linearOnce sync.Once
+)
+
+// average gets the mean of the colors given by colorOf of the samples from
+// index lo to hi, mixed in linear light.
+func (p painter) average(colorOf func(i int) color.RGBA64, lo, hi int) color.RGBA64 {
+	linearOnce.Do(func() {
+		linear = make([]float32, 0x10000)
+		for v := range linear {
+			linear[v] = float32(linearRGB(coords{float64(v) / 0xffff})[0])
+		}
+	})
+
+	var sum coords
+	var alpha float64
+	for j := lo; j < hi; j++ {
+		c := colorOf(j)
+		sum[0] += float64(linear[c.R])
+		sum[1] += float64(linear[c.G])
+		sum[2] += float64(linear[c.B])
+		alpha += float64(c.A)
+	}
+	n := float64(hi - lo)
+	for k := range sum {
+		sum[k] = math.Min(1, sum[k]/n)
+	}
+	rgb := fromLinearRGB(sum)
+	return color.RGBA64{p.channel(rgb[0]), p.channel(rgb[1]), p.channel(rgb[2]), uint16(round(alpha / n))}
+}
@@ -0,0 +1,241 @@
+package mandelbrot
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// edgeConfig is a small plot of the edge of the set, with pixels of all
+// kinds.
+func edgeConfig() Config {
+	cfg := NewConfig()
+	cfg.CenterReal, cfg.CenterImag = -0.75, 0.1
+	cfg.PlotWidth, cfg.PlotHeight = 0.5, 0.5
+	cfg.XRes, cfg.YRes = 24, 18
+	cfg.Supersample = 3
+	return cfg
+}
+
+func TestSamplerOffset(t *testing.T) {
+	s := sampler{n: 2}
+	want := [][2]float64{{-0.25, -0.25}, {0.25, -0.25}, {-0.25, 0.25}, {0.25, 0.25}}
+	for k, w := range want {
+		if dx, dy := s.offset(7, k); dx != w[0] || dy != w[1] {
+			t.Errorf("offset(7, %d) = %g, %g, want %g, %g", k, dx, dy, w[0], w[1])
+		}
+	}
+
+	jitter := sampler{n: 4, jitter: true, seed: 1}
+	other := jitter
+	other.seed = 2
+	moved := 0
+	for k := 0; k < jitter.samples(); k++ {
+		dx, dy := jitter.offset(3, k)
+		// within the sample's cell
+		left, top := float64(k%4)/4-0.5, float64(k/4)/4-0.5
+		if dx < left || dx >= left+0.25 || dy < top || dy >= top+0.25 {
+			t.Errorf("offset(3, %d) = %g, %g, outside its cell", k, dx, dy)
+		}
+		if x, y := jitter.offset(3, k); x != dx || y != dy {
+			t.Errorf("offset(3, %d) changed", k)
+		}
+		if x, y := other.offset(3, k); x != dx || y != dy {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Error("the jitter is the same for another seed")
+	}
+}
+
+func TestSupersample(t *testing.T) {
+	cfg := edgeConfig()
+	n := cfg.XRes * cfg.YRes
+	full := NewGrid(cfg.XRes, cfg.YRes)
+	if err := full.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Samples) != n+1 || int(full.Samples[n]) != n*10 || len(full.Smooth) != n*10 {
+		t.Fatalf("%d samples in slices of %d, want 9 for each of %d pixels", full.Samples[n], len(full.Smooth), n)
+	}
+
+	// the samples are those of the points around the pixels
+	s := newSampler(cfg)
+	p, err := cfg.Params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, n / 2, n - 1} {
+		x, y := i%cfg.XRes, i/cfg.XRes
+		for k := 0; k < 9; k++ {
+			dx, dy := s.offset(i, k)
+			c := complex(-1+(float64(x)+dx)*cfg.PlotWidth/24, 0.35-(float64(y)+dy)*cfg.PlotHeight/18)
+			j := int(full.Samples[i]) + k
+			if got, want := full.Iterations[j], uint32(p.Escape(c, cfg.Iterations).Iterations); got != want {
+				t.Errorf("sample %d of pixel %d has %d iterations, want %d", k, i, got, want)
+			}
+		}
+	}
+
+	// only the edges with adaptive supersampling
+	cfg.Adaptive = 1
+	var last Progress
+	adaptive := NewGrid(cfg.XRes, cfg.YRes)
+	err = adaptive.CalculateContext(context.Background(), cfg, CalcOptions{Progress: func(p Progress) { last = p }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampled := 0
+	for i := 0; i < n; i++ {
+		count := int(adaptive.Samples[i+1] - adaptive.Samples[i])
+		if count != 0 && count != 9 || (count == 9) != adaptive.edge(i, cfg.Adaptive) {
+			t.Fatalf("pixel %d has %d samples", i, count)
+		}
+		if count > 0 {
+			sampled++
+			lo, hi := adaptive.Samples[i], adaptive.Samples[i+1]
+			if !reflect.DeepEqual(adaptive.Iterations[lo:hi], full.Iterations[full.Samples[i]:full.Samples[i+1]]) {
+				t.Errorf("samples of pixel %d differ from those of full supersampling", i)
+			}
+		}
+	}
+	if sampled == 0 || sampled == n {
+		t.Errorf("%d of %d pixels supersampled; want some", sampled, n)
+	}
+	if last.Pixels != n+sampled*9 || last.Fraction() != 1 {
+		t.Errorf("last progress = %+v, want %d pixels done", last, n+sampled*9)
+	}
+
+	// calculating again without supersampling removes the samples
+	cfg.Supersample = 0
+	if err := adaptive.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if adaptive.Samples != nil || len(adaptive.Iterations) != n {
+		t.Errorf("%d samples after calculating without them", len(adaptive.Iterations)-n)
+	}
+}
+
+func TestSupersamplePerturb(t *testing.T) {
+	cfg := edgeConfig()
+	plain := NewGrid(cfg.XRes, cfg.YRes)
+	if err := plain.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Perturb = true
+	perturbed := NewGrid(cfg.XRes, cfg.YRes)
+	if err := perturbed.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(perturbed.Samples, plain.Samples) {
+		t.Fatal("perturbation placed the samples differently")
+	}
+	// perturbation counts the iteration of escape differently, and the
+	// interior is only found early without it. Some samples are still
+	// glitched when the references run out, but this shallow, they are
+	// right anyway.
+	differ := 0
+	for j := cfg.XRes * cfg.YRes; j < len(plain.Iterations); j++ {
+		in := plain.Flags[j] & FlagIn
+		d := int(perturbed.Iterations[j]) - int(plain.Iterations[j])
+		if perturbed.Flags[j]&FlagIn != in || in == 0 && (d < 0 || d > 1) {
+			differ++
+		}
+	}
+	if differ > len(plain.Iterations)/100 {
+		t.Errorf("%d of %d samples differ with perturbation", differ, len(plain.Iterations))
+	}
+
+	// deep, where the glitches of the samples are fixed with references
+	// placed among them
+	deep := cfg
+	deep.BigCenterReal = "-1.77810334274064037110522326038852639499207961414628307584575173232969154440"
+	deep.BigCenterImag = "0.00767394242121339392672671947893471774958985018535019684946671264012302378"
+	deep.BigPlotWidth = "1e-30"
+	deep.Iterations, deep.EscapeRadius = 3000, 100
+	deep.syncBig()
+	g := NewGrid(deep.XRes, deep.YRes)
+	if err := g.Calculate(deep); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < deep.XRes*deep.YRes; i++ {
+		if g.glitched(i) {
+			t.Fatalf("pixel %d is still glitched with %d references", i, g.References)
+		}
+	}
+}
+
+func TestSupersampleColors(t *testing.T) {
+	// half black and half white is the gray of half the light
+	g := NewGrid(2, 1)
+	g.setSamples(sampleOffsets(2, func(i int) int { return 2 * i }))
+	g.Flags[2] = FlagIn
+	white := []color.RGBA{{255, 255, 255, 255}}
+	img := g.Picture(white, color.RGBA{0, 0, 0, 255}).(*image.RGBA)
+	if got, want := img.RGBAAt(1, 0), (color.RGBA{188, 188, 188, 255}); got != want {
+		t.Errorf("supersampled pixel = %v, want %v", got, want)
+	}
+	if got, want := img.RGBAAt(0, 0), white[0]; got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+
+	cfg := NewConfig()
+	cfg.Depth, cfg.ImageFile = 16, "out.png"
+	deep, err := g.Colorize(white, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := deep.(*image.RGBA64).RGBA64At(1, 0).R; got>>8 != 188 {
+		t.Errorf("16-bit supersampled pixel = %#x, want about 0xbcbc", got)
+	}
+}
+
+func TestSupersampleSet(t *testing.T) {
+	cfg := edgeConfig()
+	var coords Set
+	if err := coords.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := cfg.XRes * cfg.YRes; len(coords) != n*10 {
+		t.Fatalf("Initialize() made %d jobs, want %d", len(coords), n*10)
+	}
+	coords.Calculate(cfg.Iterations)
+
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := coords.Grid(cfg.XRes, cfg.YRes); !reflect.DeepEqual(got, g) {
+		t.Error("Set.Grid() differs from a supersampled Grid")
+	}
+
+	ramp := []color.RGBA{{0, 0, 255, 255}, {255, 255, 0, 255}}
+	set := color.RGBA{0, 0, 0, 255}
+	if !reflect.DeepEqual(CreatePicture(coords, ramp, cfg.XRes, cfg.YRes, set), g.Picture(ramp, set)) {
+		t.Error("CreatePicture() differs from Grid.Picture()")
+	}
+}
+
+func TestEncodeGridSamples(t *testing.T) {
+	cfg := edgeConfig()
+	cfg.Adaptive, cfg.SamplePattern = 2, SampleJitter
+	g := NewGrid(cfg.XRes, cfg.YRes)
+	if err := g.Calculate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeGrid(&buf, g, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := DecodeGrid(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, g) {
+		t.Error("DecodeGrid() grid differs from encoded supersampled grid")
+	}
+}
@@ -0,0 +1,176 @@
+package mandelbrot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// TIFF tags written by encodeTIFF.
+const (
+	tiffImageWidth       = 256
+	tiffImageLength      = 257
+	tiffBitsPerSample    = 258
+	tiffCompression      = 259
+	tiffPhotometric      = 262
+	tiffImageDescription = 270
+	tiffStripOffsets     = 273
+	tiffSamplesPerPixel  = 277
+	tiffRowsPerStrip     = 278
+	tiffStripByteCounts  = 279
+	tiffXResolution      = 282
+	tiffYResolution      = 283
+	tiffPlanarConfig     = 284
+	tiffResolutionUnit   = 296
+	tiffSoftware         = 305
+)
+
+// TIFF field types.
+const (
+	tiffASCII    = 2
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+// tiffEntry is an entry of a TIFF's image file directory: a tag and count
+// values of the given type, already in TIFF's byte order.
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+// tiffOrder is the byte order of the TIFFs written.
+var tiffOrder = binary.LittleEndian
+
+func tiffShorts(tag uint16, values ...uint16) tiffEntry {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		tiffOrder.PutUint16(data[2*i:], v)
+	}
+	return tiffEntry{tag, tiffShort, uint32(len(values)), data}
+}
+
+func tiffLongs(tag uint16, values ...uint32) tiffEntry {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		tiffOrder.PutUint32(data[4*i:], v)
+	}
+	return tiffEntry{tag, tiffLong, uint32(len(values)), data}
+}
+
+func tiffText(tag uint16, text []byte) tiffEntry {
+	data := append(append([]byte{}, text...), 0)
+	return tiffEntry{tag, tiffASCII, uint32(len(data)), data}
+}
+
+func tiffRatio(tag uint16, numerator, denominator uint32) tiffEntry {
+	e := tiffLongs(tag, numerator, denominator)
+	e.typ, e.count = tiffRational, 1
+	return e
+}
+
+// encodeTIFF writes img as a baseline TIFF: little-endian, uncompressed RGB
+// in a single strip, with 16 bits per channel if img is an *image.RGBA64
+// and 8 otherwise. Any alpha is dropped, as the images are opaque. meta, if
+// any, is the ImageDescription.
+//
+// Format from
+// https://www.itu.int/itudoc/itu-t/com16/tiff-fx/docs/tiff6.pdf
+func encodeTIFF(w io.Writer, img image.Image, meta []byte) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	_, deep := img.(*image.RGBA64)
+	bits := uint16(8)
+	if deep {
+		bits = 16
+	}
+
+	// the pixels, in TIFF's byte order
+	pixels := make([]byte, 0, width*height*3*int(bits/8))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			for _, c := range []uint32{r, g, bl} {
+				if deep {
+					pixels = append(pixels, byte(c), byte(c>>8))
+				} else {
+					pixels = append(pixels, byte(c>>8))
+				}
+			}
+		}
+	}
+
+	entries := []tiffEntry{
+		tiffLongs(tiffImageWidth, uint32(width)),
+		tiffLongs(tiffImageLength, uint32(height)),
+		tiffShorts(tiffBitsPerSample, bits, bits, bits),
+		tiffShorts(tiffCompression, 1),
+		tiffShorts(tiffPhotometric, 2), // RGB
+	}
+	if meta != nil {
+		entries = append(entries, tiffText(tiffImageDescription, meta))
+	}
+	entries = append(entries,
+		tiffLongs(tiffStripOffsets, 0), // set by writeTIFF
+		tiffShorts(tiffSamplesPerPixel, 3),
+		tiffLongs(tiffRowsPerStrip, uint32(height)),
+		tiffLongs(tiffStripByteCounts, uint32(len(pixels))),
+		tiffRatio(tiffXResolution, 72, 1),
+		tiffRatio(tiffYResolution, 72, 1),
+		tiffShorts(tiffPlanarConfig, 1),   // chunky
+		tiffShorts(tiffResolutionUnit, 2), // inches
+		tiffText(tiffSoftware, []byte(software)))
+	return writeTIFF(w, entries, pixels)
+}
+
+// writeTIFF writes a TIFF with one image, whose directory has the given
+// entries in order of their tags, and whose single strip is pixels. The
+// StripOffsets entry is set to where the pixels are written.
+func writeTIFF(w io.Writer, entries []tiffEntry, pixels []byte) error {
+	// the header, then the directory, then the values which don't fit in
+	// their entries, each at a word boundary, then the pixels
+	const headerSize, entrySize = 8, 12
+	valueOffset := headerSize + 2 + len(entries)*entrySize + 4
+	pixelOffset := valueOffset
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			pixelOffset += (len(e.data) + 1) &^ 1
+		}
+	}
+	for i := range entries {
+		if entries[i].tag == tiffStripOffsets {
+			entries[i] = tiffLongs(tiffStripOffsets, uint32(pixelOffset))
+		}
+	}
+
+	var dir, values bytes.Buffer
+	dir.Write([]byte{'I', 'I', 42, 0})
+	binary.Write(&dir, tiffOrder, uint32(headerSize))
+	binary.Write(&dir, tiffOrder, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&dir, tiffOrder, e.tag)
+		binary.Write(&dir, tiffOrder, e.typ)
+		binary.Write(&dir, tiffOrder, e.count)
+		if len(e.data) <= 4 {
+			dir.Write(e.data)
+			dir.Write(make([]byte, 4-len(e.data)))
+			continue
+		}
+		binary.Write(&dir, tiffOrder, uint32(valueOffset+values.Len()))
+		values.Write(e.data)
+		if len(e.data)%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(&dir, tiffOrder, uint32(0)) // no more directories
+
+	for _, b := range [][]byte{dir.Bytes(), values.Bytes(), pixels} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package mandelbrot
+
+import (
+	"fmt"
+	"image"
+	_ "image/png" // for image traps; image/jpeg is already imported
+	"math"
+	"math/cmplx"
+	"os"
+)
+
+// Trap types for Trap.Type.
+const (
+	TrapPoint  = "point"  // the point X + Yi
+	TrapLine   = "line"   // the line through X + Yi at Angle
+	TrapCircle = "circle" // the circle of Radius around X + Yi
+	TrapCross  = "cross"  // the lines through X + Yi at Angle and at right angles to it
+	TrapImage  = "image"  // an Image, Width across, centred at X + Yi and turned by Angle
+)
+
+// Trap is a shape in the plane to which the distance of orbits is measured
+// for orbit trap coloring. For an image trap, the distance is 0 on dark,
+// opaque pixels, up to 1 on light or transparent ones and off the image.
+type Trap struct {
+	Type   string  `json:"type"` // see the Trap* constants
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Radius float64 `json:"radius,omitempty"`
+	Angle  float64 `json:"angle,omitempty"` // in degrees, anticlockwise
+	Image  string  `json:"image,omitempty"` // file with the image of an image trap
+	Width  float64 `json:"width,omitempty"` // of an image trap, in the plane
+
+	turn complex128 // rotation by -Angle
+	img  *trapImage
+}
+
+// trapImage is the image of an image trap, as the distance at each pixel.
+type trapImage struct {
+	dist          []float32
+	width, height int
+	scale         float64 // pixels per unit of the plane
+}
+
+// Traps are the traps whose least distance from the orbit is measured.
+type Traps []Trap
+
+// NewTraps gets Traps which are ready to use, loading the images of image
+// traps. The error is a *ConfigError for the "traps" field.
+func NewTraps(traps []Trap) (Traps, error) {
+	if len(traps) == 0 {
+		return nil, nil
+	}
+	ts := make(Traps, len(traps))
+	for i, t := range traps {
+		bad := func(reason string, args ...interface{}) error {
+			return &ConfigError{Field: "traps", Reason: fmt.Sprintf("%d %s", i, fmt.Sprintf(reason, args...))}
+		}
+		switch t.Type {
+		case TrapPoint, TrapLine, TrapCross:
+		case TrapCircle:
+			if !(t.Radius > 0) {
+				return nil, bad("radius must be positive, not %g", t.Radius)
+			}
+		case TrapImage:
+			if !(t.Width > 0) {
+				return nil, bad("width must be positive, not %g", t.Width)
+			}
+			img, err := readTrapImage(t.Image)
+			if err != nil {
+				return nil, bad("image %v", err)
+			}
+			img.scale = float64(img.width) / t.Width
+			t.img = img
+		default:
+			return nil, bad("type '%s' is unknown", t.Type)
+		}
+		t.turn = cmplx.Rect(1, -t.Angle*math.Pi/180)
+		ts[i] = t
+	}
+	return ts, nil
+}
+
+// readTrapImage reads the image of an image trap from filename.
+func readTrapImage(filename string) (*trapImage, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	b := img.Bounds()
+	t := &trapImage{dist: make([]float32, b.Dx()*b.Dy()), width: b.Dx(), height: b.Dy()}
+	for y, i := b.Min.Y, 0; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x, i = x+1, i+1 {
+			// as if over white, which is light; r, g and b are premultiplied
+			r, g, b, a := img.At(x, y).RGBA()
+			light := (0.299*float64(r)+0.587*float64(g)+0.114*float64(b))/0xffff + 1 - float64(a)/0xffff
+			t.dist[i] = float32(math.Min(1, light))
+		}
+	}
+	return t, nil
+}
+
+// distance gets the distance from z to the trap, which must be one of those
+// made ready by NewTraps.
+func (t *Trap) distance(z complex128) float64 {
+	w := (z - complex(t.X, t.Y)) * t.turn
+	switch t.Type {
+	case TrapPoint:
+		return cmplx.Abs(w)
+	case TrapLine:
+		return math.Abs(imag(w))
+	case TrapCircle:
+		return math.Abs(cmplx.Abs(w) - t.Radius)
+	case TrapCross:
+		return math.Min(math.Abs(real(w)), math.Abs(imag(w)))
+	case TrapImage:
+		x := int(math.Floor(real(w)*t.img.scale + float64(t.img.width)/2))
+		y := int(math.Floor(float64(t.img.height)/2 - imag(w)*t.img.scale))
+		if x < 0 || x >= t.img.width || y < 0 || y >= t.img.height {
+			return 1
+		}
+		return float64(t.img.dist[y*t.img.width+x])
+	}
+	return math.Inf(1)
+}
+
+// closest returns the least of d and the distances from z to the traps.
+func (ts Traps) closest(d float64, z complex128) float64 {
+	for i := range ts {
+		if t := ts[i].distance(z); t < d {
+			d = t
+		}
+	}
+	return d
+}
+
+// trapped sets the Trap of r to d, if there are traps.
+func (ts Traps) trapped(r Result, d float64) Result {
+	if ts != nil {
+		r.Trap = d
+	}
+	return r
+}
@@ -0,0 +1,132 @@
+package mandelbrot
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrapDistance(t *testing.T) {
+	traps, err := NewTraps([]Trap{
+		{Type: TrapPoint, X: 1, Y: 1},
+		{Type: TrapLine, X: 0, Y: 1, Angle: 45},
+		{Type: TrapCircle, X: -1, Radius: 0.5},
+		{Type: TrapCross, X: 2, Y: 2, Angle: 90},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		trap int
+		z    complex128
+		want float64
+	}{
+		{0, 4 + 5i, 5},
+		{1, 1 + 2i, 0},
+		{1, 1, math.Sqrt2},
+		{2, -1, 0.5},
+		{2, -1 + 2i, 1.5},
+		{3, 2.5 + 2.25i, 0.25},
+	}
+	for _, tt := range tests {
+		if got := traps[tt.trap].distance(tt.z); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s trap distance to %v = %g, want %g", traps[tt.trap].Type, tt.z, got, tt.want)
+		}
+	}
+	if got := traps.closest(math.Inf(1), 1+1i); got != 0 {
+		t.Errorf("closest() = %g, want 0", got)
+	}
+}
+
+func TestImageTrap(t *testing.T) {
+	// black on the left, white on the right, transparent below
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.NRGBA{uint8(255 * (x / 2)), uint8(255 * (x / 2)), uint8(255 * (x / 2)), 255})
+		}
+	}
+	filename := filepath.Join(t.TempDir(), "trap.png")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(file, img)
+	file.Close()
+
+	traps, err := NewTraps([]Trap{{Type: TrapImage, Image: filename, Width: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		z    complex128
+		want float64
+	}{
+		{-0.5 + 0.5i, 0},
+		{0.5 + 0.5i, 1},
+		{-0.5 - 0.5i, 1},
+		{3, 1},
+	} {
+		if got := traps[0].distance(tt.z); got != tt.want {
+			t.Errorf("image trap distance to %v = %g, want %g", tt.z, got, tt.want)
+		}
+	}
+}
+
+func TestTrapEscape(t *testing.T) {
+	traps, _ := NewTraps([]Trap{{Type: TrapPoint}})
+	p := Params{Traps: traps, Interior: true}
+
+	// the orbit of -1 goes 0, -1, 0...
+	if got := p.Escape(-1, 100); !got.In || got.Trap != 0 {
+		t.Errorf("Escape(-1) = %+v, want in with trap 0", got)
+	}
+	// 0.25 approaches 0.5, passing closest at the start
+	if got := p.Escape(0.25, 100); !got.In || math.Abs(got.Trap-0.25) > 1e-12 {
+		t.Errorf("Escape(0.25) = %+v, want in with trap 0.25", got)
+	}
+	// -2.5 goes 3.75, ...
+	if got := p.Escape(-2.5, 100); got.In || got.Trap != 2.5 {
+		t.Errorf("Escape(-2.5) = %+v, want out with trap 2.5", got)
+	}
+
+	// the other formulas, and the Set pipeline, track it too
+	f, _ := NewFormula(FormulaTricorn, 2)
+	p.Formula = f
+	if got := p.Escape(-2.5, 100); got.Trap != 2.5 {
+		t.Errorf("tricorn Escape(-2.5).Trap = %g, want 2.5", got.Trap)
+	}
+	e, _ := NewExpression("z^2 + c", "")
+	p.Formula, p.Expression = nil, e
+	if got := p.Escape(-2.5, 100); got.Trap != 2.5 {
+		t.Errorf("expression Escape(-2.5).Trap = %g, want 2.5", got.Trap)
+	}
+	p.Expression = nil
+	j := NewBigJob(-2.5, 0, 0, 0)
+	j.p = &p
+	j.RunMandelbrot(100)
+	if got := j.GetResult(); got.Trap != 2.5 {
+		t.Errorf("BigJob Trap = %g, want 2.5", got.Trap)
+	}
+}
+
+func TestTrapConfigErrors(t *testing.T) {
+	for _, trap := range []Trap{
+		{Type: "star"},
+		{Type: TrapCircle},
+		{Type: TrapImage, Image: "no such file.png", Width: 1},
+		{Type: TrapImage, Image: "trap.png"},
+	} {
+		cfg := NewConfig()
+		cfg.Traps = []Trap{{Type: TrapPoint}, trap}
+		var ce *ConfigError
+		if err := cfg.Validate(); !errors.As(err, &ce) || ce.Field != "traps" {
+			t.Errorf("Validate() with %+v error = %v, want one for traps", trap, err)
+		}
+	}
+}